@@ -1,12 +1,14 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
 	"github.com/MichalPitr/map_reduce/pkg/interfaces"
 	"github.com/MichalPitr/map_reduce/pkg/mapreduce"
 )
@@ -15,6 +17,11 @@ type WordCounter struct {
 	wordRegex *regexp.Regexp
 }
 
+func (wc *WordCounter) Setup(ctx context.Context) error {
+	wc.wordRegex = regexp.MustCompile(`\b\w+\b`)
+	return nil
+}
+
 func (wc *WordCounter) Map(input interfaces.MapInput, emit func(key, value string)) {
 	text := input.Value()
 	text = strings.ToLower(text)
@@ -24,31 +31,43 @@ func (wc *WordCounter) Map(input interfaces.MapInput, emit func(key, value strin
 	}
 }
 
+// CountFrequency reads the word,count output of a word count and counts how
+// many words occur each number of times.
+type CountFrequency struct{}
+
+func (cf *CountFrequency) Map(input interfaces.MapInput, emit func(key, value string)) {
+	_, count, ok := strings.Cut(input.Value(), ",")
+	if !ok {
+		slog.Warn("Expected a word,count record, skipping", "value", input.Value())
+		return
+	}
+	emit(count, "1")
+}
+
 type Adder struct{}
 
 func (a *Adder) Reduce(input interfaces.ReducerInput, emit func(value string)) {
 	val := 0
-	for !input.Done() {
-		num, err := strconv.Atoi(input.Value())
+	for value := range input.Values() {
+		num, err := strconv.Atoi(value)
 		if err != nil {
-			log.Printf("Failed converting input to integer, skipping: %q", input.Value())
-			input.NextValue()
+			slog.Warn("Failed converting input to integer, skipping", "value", value)
+			counters.FromContext(input.Context()).Inc("adder.malformed_records")
 			continue
 		}
 		val += num
-		input.NextValue()
 	}
 	emit(strconv.Itoa(val))
 }
 
 func main() {
 	cfg := config.SetupJobConfig()
-	log.Printf("cfg: %v", cfg)
-	cfg.NumReducers = 2
-	cfg.NumMappers = 4
 
-	cfg.Mapper = &WordCounter{wordRegex: regexp.MustCompile(`\b\w+\b`)}
+	cfg.Mapper = &WordCounter{}
 	cfg.Reducer = &Adder{}
+	cfg.RegisterMapper("wordcount", cfg.Mapper)
+	cfg.RegisterMapper("frequency", &CountFrequency{})
+	cfg.RegisterReducer("sum", cfg.Reducer)
 
 	mapreduce.Execute(cfg)
 }
@@ -0,0 +1,229 @@
+// Package cache implements the distributed cache of side files, like stop
+// word lists or lookup tables, that every task of a job reads. The master
+// stages the declared files once into the job directory, and every task
+// copies them to a local directory and verifies their checksums before it
+// processes records.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+)
+
+// DirName is the directory in the job directory holding the staged files.
+const DirName = "cache"
+
+// manifestFile lists the staged entries and their checksums. It is written
+// last, so that tasks never see a partially staged cache.
+const manifestFile = "manifest.json"
+
+// validName matches names of cache entries, which are also their file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Manifest maps the names of staged entries to their checksums.
+type Manifest map[string]string
+
+// Stage copies the files or directories in sources, keyed by name, to dir and
+// writes their manifest. Entries staged before are replaced.
+func Stage(dir string, sources map[string]string) (Manifest, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	manifest := make(Manifest, len(sources))
+	for name, source := range sources {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("invalid cache entry name %q", name)
+		}
+		target := filepath.Join(dir, name)
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+		if err := copyTree(source, target); err != nil {
+			return nil, fmt.Errorf("staging %s: %w", name, err)
+		}
+		sum, err := Checksum(target)
+		if err != nil {
+			return nil, fmt.Errorf("staging %s: %w", name, err)
+		}
+		manifest[name] = sum
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	temp := filepath.Join(dir, manifestFile+".tmp")
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, os.Rename(temp, filepath.Join(dir, manifestFile))
+}
+
+// Staged reports whether dir holds a staged cache.
+func Staged(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, manifestFile))
+	return err == nil
+}
+
+// Load localizes the cache staged for the job of a task into a directory of
+// cfg.CacheDir. Jobs without a staged cache get an empty cache.
+func Load(cfg *config.Config) (*Cache, error) {
+	dir := filepath.Join(cfg.JobDir(), DirName)
+	if cfg.JobId == "" || !Staged(dir) {
+		return &Cache{}, nil
+	}
+	return Localize(dir, filepath.Join(cfg.CacheDir, cfg.JobId))
+}
+
+// Cache holds the local paths of the cache entries of a task.
+type Cache struct {
+	paths map[string]string
+}
+
+// Localize copies the entries staged in dir to localDir and verifies their
+// checksums against the manifest.
+func Localize(dir, localDir string) (*Cache, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", manifestFile, err)
+	}
+
+	c := &Cache{paths: make(map[string]string, len(manifest))}
+	for name, want := range manifest {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("invalid cache entry name %q", name)
+		}
+		local := filepath.Join(localDir, name)
+		if err := os.RemoveAll(local); err != nil {
+			return nil, err
+		}
+		if err := copyTree(filepath.Join(dir, name), local); err != nil {
+			return nil, fmt.Errorf("localizing %s: %w", name, err)
+		}
+		got, err := Checksum(local)
+		if err != nil {
+			return nil, fmt.Errorf("localizing %s: %w", name, err)
+		}
+		if got != want {
+			return nil, fmt.Errorf("checksum mismatch of cache entry %s: got %s, want %s", name, got, want)
+		}
+		c.paths[name] = local
+	}
+	return c, nil
+}
+
+// Path returns the local path of the named entry.
+func (c *Cache) Path(name string) (string, bool) {
+	path, ok := c.paths[name]
+	return path, ok
+}
+
+// Checksum returns the SHA-256 checksum of a file, or of the relative paths
+// and contents of all files in a directory.
+func Checksum(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\n", filepath.ToSlash(rel), sum)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyTree copies the file or directory source to target.
+func copyTree(source, target string) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(dest, 0777)
+		}
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		return copyFile(path, dest)
+	})
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the cache of a task.
+func NewContext(ctx context.Context, c *Cache) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the cache of the task running with ctx. Outside a task,
+// e.g. in tests of a single Map function, it returns a cache without
+// entries, whose Path reports every name as missing.
+func FromContext(ctx context.Context) *Cache {
+	if c, ok := ctx.Value(contextKey{}).(*Cache); ok {
+		return c
+	}
+	return &Cache{}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// stageTestCache stages a file and a directory and returns the staged dir.
+func stageTestCache(t *testing.T) string {
+	t.Helper()
+	sources := t.TempDir()
+	writeFile(t, filepath.Join(sources, "stopwords.txt"), "a\nthe\n")
+	writeFile(t, filepath.Join(sources, "tables", "users"), "1,ann\n")
+	writeFile(t, filepath.Join(sources, "tables", "nested", "orders"), "1,o1\n")
+
+	dir := filepath.Join(t.TempDir(), DirName)
+	manifest, err := Stage(dir, map[string]string{
+		"stopwords": filepath.Join(sources, "stopwords.txt"),
+		"tables":    filepath.Join(sources, "tables"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 2 || !strings.HasPrefix(manifest["stopwords"], "sha256:") {
+		t.Errorf("manifest = %v", manifest)
+	}
+	if !Staged(dir) {
+		t.Error("cache is not staged")
+	}
+	return dir
+}
+
+func TestStageAndLocalize(t *testing.T) {
+	dir := stageTestCache(t)
+	localDir := t.TempDir()
+	c, err := Localize(dir, localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopwords, ok := c.Path("stopwords")
+	if !ok || stopwords != filepath.Join(localDir, "stopwords") {
+		t.Fatalf("stopwords path = %q, %v", stopwords, ok)
+	}
+	if got := readFile(t, stopwords); got != "a\nthe\n" {
+		t.Errorf("stopwords = %q", got)
+	}
+	tables, _ := c.Path("tables")
+	if got := readFile(t, filepath.Join(tables, "nested", "orders")); got != "1,o1\n" {
+		t.Errorf("orders = %q", got)
+	}
+	if _, ok := c.Path("missing"); ok {
+		t.Error("found an entry that was not staged")
+	}
+
+	// Localizing again, e.g. in a retried task, replaces the local copy.
+	if _, err := Localize(dir, localDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalizeVerifiesChecksums(t *testing.T) {
+	dir := stageTestCache(t)
+	writeFile(t, filepath.Join(dir, "tables", "users"), "1,eve\n")
+
+	_, err := Localize(dir, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch of cache entry tables") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestStageErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Stage(dir, map[string]string{"../escape": dir}); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+	if _, err := Stage(dir, map[string]string{"missing": filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+	if Staged(dir) {
+		t.Error("failed staging wrote a manifest")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	nfsPath := t.TempDir()
+	cfg := &config.Config{NfsPath: nfsPath, JobId: "job-1", CacheDir: t.TempDir()}
+
+	// Jobs without cache files get an empty cache.
+	c, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Path("stopwords"); ok {
+		t.Error("unexpected cache entry")
+	}
+
+	source := filepath.Join(t.TempDir(), "stopwords.txt")
+	writeFile(t, source, "the\n")
+	if _, err := Stage(filepath.Join(cfg.JobDir(), DirName), map[string]string{"stopwords": source}); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext(context.Background(), c)
+	path, ok := FromContext(ctx).Path("stopwords")
+	if !ok || path != filepath.Join(cfg.CacheDir, "job-1", "stopwords") {
+		t.Errorf("stopwords path = %q, %v", path, ok)
+	}
+	if _, ok := FromContext(context.Background()).Path("stopwords"); ok {
+		t.Error("empty context has a cache entry")
+	}
+}
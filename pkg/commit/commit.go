@@ -0,0 +1,144 @@
+package commit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempDirName is the directory next to the final output that task attempts
+// write to before committing.
+const TempDirName = "_temporary"
+
+// TempPath returns where an attempt of a task writes the output that is later
+// committed to final. A trailing slash in final does not change the path.
+func TempPath(final string, attempt int) string {
+	final = filepath.Clean(final)
+	return filepath.Join(filepath.Dir(final), TempDirName, fmt.Sprintf("%s-%d", filepath.Base(final), attempt))
+}
+
+// checkPaths returns an error when temp is inside final, where writing the
+// attempt would create final and look like a committed output.
+func checkPaths(temp, final string) error {
+	rel, err := filepath.Rel(filepath.Clean(final), filepath.Clean(temp))
+	if err != nil {
+		return err
+	}
+	if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("temporary output %s is inside the final output %s", temp, final)
+	}
+	return nil
+}
+
+// MarkerDirName is the directory next to committed outputs that holds their
+// commit markers.
+const MarkerDirName = "_committed"
+
+// rename moves committed outputs into place. Tests replace it to inject
+// failures.
+var rename = os.Rename
+
+// markerPath returns the commit marker of the output final.
+func markerPath(final string) string {
+	final = filepath.Clean(final)
+	return filepath.Join(filepath.Dir(final), MarkerDirName, filepath.Base(final))
+}
+
+// Committed reports whether a task has committed its output to final. An
+// output is only committed once its commit marker exists, so outputs that an
+// attempt moved into place before failing do not count.
+func Committed(final string) bool {
+	_, err := os.Stat(markerPath(final))
+	return err == nil
+}
+
+// Commit moves the output of a task attempt from temp to final and marks it
+// committed. Once final is committed, later attempts discard the output in
+// temp and Commit returns false.
+func Commit(temp, final string) (bool, error) {
+	return CommitAll(temp, final, nil)
+}
+
+// CommitAll commits the output of a task attempt together with side outputs,
+// e.g. named outputs, given as a map from temporary to final paths. All of
+// them are moved into place before the commit marker of final is written, so
+// the task only counts as committed once every output is complete. Outputs
+// left behind by attempts that failed before writing the marker are replaced.
+// When final is already committed, all outputs of the attempt are discarded
+// and CommitAll returns false.
+func CommitAll(temp, final string, sides map[string]string) (bool, error) {
+	final = filepath.Clean(final)
+	if err := checkPaths(temp, final); err != nil {
+		return false, err
+	}
+	if Committed(final) {
+		return false, discard(temp, sides)
+	}
+
+	if err := move(temp, final); err != nil {
+		return false, err
+	}
+	for sideTemp, sideFinal := range sides {
+		if err := move(sideTemp, sideFinal); err != nil {
+			return false, err
+		}
+	}
+
+	marker := markerPath(final)
+	if err := os.MkdirAll(filepath.Dir(marker), 0777); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return false, fmt.Errorf("committing %s: %w", final, err)
+	}
+	return true, nil
+}
+
+// ErrExists is returned by CommitTask when a task run outside a job finds
+// its output already exists.
+var ErrExists = errors.New("output already exists")
+
+// CommitTask commits the outputs of a task attempt like CommitAll. Only tasks
+// of a job, i.e. with inJob set, have other attempts, so only they return
+// false when another attempt committed first. A task run on its own must not
+// silently drop or replace an existing output: it discards its outputs and
+// returns ErrExists.
+func CommitTask(temp, final string, sides map[string]string, inJob bool) (bool, error) {
+	final = filepath.Clean(final)
+	if !inJob {
+		if _, err := os.Stat(final); err == nil || Committed(final) {
+			if err := discard(temp, sides); err != nil {
+				return false, err
+			}
+			return false, fmt.Errorf("%w: %s", ErrExists, final)
+		}
+	}
+	return CommitAll(temp, final, sides)
+}
+
+// discard removes the outputs of an attempt that is not committed.
+func discard(temp string, sides map[string]string) error {
+	err := os.RemoveAll(temp)
+	for sideTemp := range sides {
+		if rmErr := os.RemoveAll(sideTemp); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// move replaces target with source.
+func move(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := rename(source, target); err != nil {
+		return fmt.Errorf("committing %s: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package commit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFirstCommitWins(t *testing.T) {
+	final := filepath.Join(t.TempDir(), "reducer-0")
+	for attempt, content := range []string{"first", "second"} {
+		temp := TempPath(final, attempt)
+		if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(temp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		committed, err := Commit(temp, final)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if committed != (attempt == 0) {
+			t.Errorf("attempt %d: committed = %v", attempt, committed)
+		}
+		if _, err := os.Stat(temp); !os.IsNotExist(err) {
+			t.Errorf("attempt %d: temporary output was not removed", attempt)
+		}
+	}
+
+	got, err := os.ReadFile(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first" {
+		t.Errorf("final output = %q, want %q", got, "first")
+	}
+}
+
+func TestCommitAll(t *testing.T) {
+	dir := t.TempDir()
+	final := filepath.Join(dir, "reducer-0")
+	sideFinal := filepath.Join(dir, "errors", "reducer-0")
+	// An attempt that failed before committing left a side output behind.
+	if err := os.MkdirAll(filepath.Dir(sideFinal), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sideFinal, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt, content := range []string{"first", "second"} {
+		temp := TempPath(final, attempt)
+		sideTemp := temp + ".errors"
+		if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{temp, sideTemp} {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		committed, err := CommitAll(temp, final, map[string]string{sideTemp: sideFinal})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if committed != (attempt == 0) {
+			t.Errorf("attempt %d: committed = %v", attempt, committed)
+		}
+		if _, err := os.Stat(sideTemp); !os.IsNotExist(err) {
+			t.Errorf("attempt %d: temporary side output was not removed", attempt)
+		}
+	}
+
+	for _, path := range []string{final, sideFinal} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "first" {
+			t.Errorf("%s = %q, want %q", path, got, "first")
+		}
+	}
+}
+
+func TestTempPathIgnoresTrailingSlash(t *testing.T) {
+	dir := t.TempDir()
+	final := filepath.Join(dir, "mapper-0")
+	if got, want := TempPath(final+"/", 1), TempPath(final, 1); got != want {
+		t.Errorf("TempPath = %q, want %q", got, want)
+	}
+}
+
+func TestCommitRejectsTempInsideFinal(t *testing.T) {
+	final := filepath.Join(t.TempDir(), "mapper-0")
+	temp := filepath.Join(final, TempDirName, "mapper-0-0")
+	if err := os.MkdirAll(temp, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Commit(temp, final); err == nil {
+		t.Error("expected an error for a temporary path inside the final output")
+	}
+	if _, err := CommitAll(temp, final+"/", nil); err == nil {
+		t.Error("expected an error for a temporary path inside the final output")
+	}
+}
+
+func TestCommitAllFailsBeforeMarker(t *testing.T) {
+	dir := t.TempDir()
+	final := filepath.Join(dir, "reducer-0")
+	sideFinal := filepath.Join(dir, "errors", "reducer-0")
+	write := func(attempt int, content string) (string, string) {
+		temp := TempPath(final, attempt)
+		sideTemp := temp + ".errors"
+		if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{temp, sideTemp} {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return temp, sideTemp
+	}
+
+	// The first attempt fails after moving its main output into place.
+	rename = func(source, target string) error {
+		if target == sideFinal {
+			return errors.New("injected failure")
+		}
+		return os.Rename(source, target)
+	}
+	defer func() { rename = os.Rename }()
+	temp, sideTemp := write(0, "first")
+	if committed, err := CommitAll(temp, final, map[string]string{sideTemp: sideFinal}); err == nil || committed {
+		t.Fatalf("CommitAll = %v, %v, want an error", committed, err)
+	}
+	if Committed(final) {
+		t.Fatal("output with a missing side output counts as committed")
+	}
+
+	// A retried attempt replaces the partial output.
+	rename = os.Rename
+	temp, sideTemp = write(1, "second")
+	committed, err := CommitAll(temp, final, map[string]string{sideTemp: sideFinal})
+	if err != nil || !committed {
+		t.Fatalf("CommitAll = %v, %v", committed, err)
+	}
+	for _, path := range []string{final, sideFinal} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "second" {
+			t.Errorf("%s = %q, want %q", path, got, "second")
+		}
+	}
+	if !Committed(final) {
+		t.Error("output is not committed")
+	}
+}
+
+func TestCommitTask(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		inJob bool
+		// committed is the result of the second attempt.
+		committed bool
+		err       error
+	}{
+		{"task of a job", true, false, nil},
+		{"task outside a job", false, false, ErrExists},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			final := filepath.Join(t.TempDir(), "reducer-0")
+			for attempt := range 2 {
+				temp := TempPath(final, attempt)
+				if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(temp, []byte("output"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				committed, err := CommitTask(temp, final, nil, tc.inJob)
+				if attempt == 0 {
+					if !committed || err != nil {
+						t.Fatalf("first attempt: committed = %v, err = %v", committed, err)
+					}
+					continue
+				}
+				if committed != tc.committed || !errors.Is(err, tc.err) {
+					t.Errorf("second attempt: committed = %v, err = %v, want %v, %v", committed, err, tc.committed, tc.err)
+				}
+				if _, err := os.Stat(temp); !os.IsNotExist(err) {
+					t.Error("temporary output was not removed")
+				}
+			}
+		})
+	}
+}
+
+func TestCommitTaskKeepsUncommittedOutput(t *testing.T) {
+	// A task run outside a job must not replace an output that it did not
+	// write, even without a commit marker.
+	final := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(final, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	temp := TempPath(final, 0)
+	if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(temp, []byte("task"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CommitTask(temp, final, nil, false); !errors.Is(err, ErrExists) {
+		t.Errorf("err = %v, want %v", err, ErrExists)
+	}
+	if got, err := os.ReadFile(final); err != nil || string(got) != "mine" {
+		t.Errorf("final output = %q, %v", got, err)
+	}
+}
@@ -2,10 +2,38 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/MichalPitr/map_reduce/pkg/input"
 	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/homedir"
 )
 
+// TaskResources are the CPU and memory requests and limits of a task's pod,
+// in Kubernetes quantity format, e.g. 500m or 1Gi. Empty values are not set.
+type TaskResources struct {
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+}
+
+// Input is one of several tagged inputs of a job. Its files are read by
+// Mapper in the input format Format, or by the mapper and in the input format
+// of the job when they are empty.
+type Input struct {
+	Tag    string `json:"tag"`
+	Dir    string `json:"dir"`
+	Mapper string `json:"mapper,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
 type Config struct {
 	Mode        string
 	InputDir    string
@@ -14,27 +42,270 @@ type Config struct {
 	NumReducers int
 	NumMappers  int
 	ReducerId   int
+	JobId       string
+	TaskId      string
+	Attempt     int
 	NfsPath     string
 	Image       string
+	Kubeconfig  string
+	Resume      string
+	Timeout     time.Duration
+	HttpAddr    string
+	LogLevel    string
+	LogFormat   string
+
+	// DeleteIntermediate removes mapper output once the job succeeded.
+	DeleteIntermediate bool
+
+	// WorkerMetricsPort is the port worker pods serve /metrics on. Zero disables it.
+	WorkerMetricsPort int
+
+	// Scheduling of task pods.
+	MapperResources   TaskResources
+	ReducerResources  TaskResources
+	NodeSelector      map[string]string
+	Tolerations       []v1.Toleration
+	SpreadTasks       bool
+	PriorityClassName string
+
+	// Namespace of the Kubernetes Jobs and pods of all tasks.
+	Namespace string
+
+	// NfsVolume and NfsClaim name the shared volume mounted at NfsPath in
+	// task pods. NfsSubPath mounts a directory of the volume instead of its root.
+	NfsVolume  string
+	NfsClaim   string
+	NfsSubPath string
+
+	// Env, EnvFrom, Volumes and VolumeMounts are added to task pods. Each
+	// entry of VolumeMounts mounts the volume at the same index in Volumes.
+	Env          []v1.EnvVar
+	EnvFrom      []v1.EnvFromSource
+	Volumes      []v1.Volume
+	VolumeMounts []v1.VolumeMount
+
+	// Inputs replace InputDir for jobs that read several tagged inputs, e.g.
+	// both sides of a join. InputTag is the tag of the input a mapper reads.
+	Inputs   []Input
+	InputTag string
+
+	// CacheFiles maps names of side files or directories to their paths. The
+	// master stages them once and tasks copy them to CacheDir.
+	CacheFiles map[string]string
+	CacheDir   string
+
+	// Pipeline is the path of a pipeline spec run in pipeline mode.
+	Pipeline string
 
 	Mapper  interfaces.Mapper
 	Reducer interfaces.Reducer
+	// KeyedReducer is used instead of Reducer when set.
+	KeyedReducer interfaces.KeyedReducer
+
+	// Mappers and Reducers are registered by name, so that the stages of a
+	// pipeline can use different functions. MapperName and ReducerName
+	// select the functions of a job.
+	Mappers       map[string]interfaces.Mapper
+	Reducers      map[string]interfaces.Reducer
+	KeyedReducers map[string]interfaces.KeyedReducer
+	MapperName    string
+	ReducerName   string
+
+	// OutputFormat encodes the final output of a job. It is selected by
+	// OutputFormatName from the registered and the built-in formats.
+	OutputFormat     interfaces.OutputFormat
+	OutputFormats    map[string]interfaces.OutputFormat
+	OutputFormatName string
+
+	// InputFormat decodes the input files of a mapper. It is selected by
+	// InputFormatName from the registered and the built-in formats.
+	InputFormat     interfaces.InputFormat
+	InputFormats    map[string]interfaces.InputFormat
+	InputFormatName string
 }
 
 func SetupJobConfig() *Config {
 	cfg := &Config{}
 	// Common flags
-	flag.StringVar(&cfg.Mode, "mode", "", "Mode of operation: master, mapper, reducer.")
+	flag.StringVar(&cfg.Mode, "mode", "", "Mode of operation: master, mapper, reducer, cleanup, deploy, controller, pipeline.")
+	flag.StringVar(&cfg.Pipeline, "pipeline", "", "Path of the pipeline spec to run in pipeline mode.")
+	flag.StringVar(&cfg.MapperName, "mapper", "", "Name of the registered mapper to run. Defaults to the mapper set in code.")
+	flag.StringVar(&cfg.ReducerName, "reducer", "", "Name of the registered reducer to run. Defaults to the reducer set in code.")
+	flag.StringVar(&cfg.OutputFormatName, "output-format", output.Text, "Format of the final output: "+strings.Join(output.Names(), ", ")+" or a registered format.")
 	flag.StringVar(&cfg.InputDir, "input-dir", "", "Path to input directory.")
+	flag.StringVar(&cfg.InputFormatName, "input-format", input.Text, "Format of the input files: "+strings.Join(input.Names(), ", ")+" or a registered format.")
+	flag.Var((*inputsFlag)(&cfg.Inputs), "input", "Tagged input in the format tag=name,mapper=name,format=name,dir=path, instead of --input-dir. Mapper and format default to --mapper and --input-format. Each input is split between --num-mappers mappers. Can be repeated.")
+	flag.StringVar(&cfg.InputTag, "input-tag", "", "Tag of the input a mapper reads.")
 	flag.StringVar(&cfg.OutputDir, "output-dir", "", "Path to output directory.")
-	flag.IntVar(&cfg.NumReducers, "num-reducers", 1, "Number of reducers to use.")
+	flag.Var((*mapFlag)(&cfg.CacheFiles), "cache-file", "Side file or directory every task reads, in the format name=path, e.g. stopwords=/mnt/nfs/stopwords.txt. Can be repeated.")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", filepath.Join(os.TempDir(), "mapreduce-cache"), "Local directory tasks copy cache files to.")
+	flag.IntVar(&cfg.NumReducers, "num-reducers", 1, "Number of reducers to use. 0 runs a map-only job whose mappers write the final output.")
 	flag.IntVar(&cfg.NumMappers, "num-mappers", 1, "Number of mappers to use.")
 	flag.StringVar(&cfg.Image, "image", "", "Image with the binary.")
+	flag.StringVar(&cfg.Kubeconfig, "kubeconfig", defaultKubeconfig(), "Path to the kubeconfig file used by the master.")
+	flag.StringVar(&cfg.Resume, "resume", "", "Id of an interrupted job to resume. Tasks with committed output are skipped.")
+	flag.DurationVar(&cfg.Timeout, "timeout", 0, "Maximum runtime of the job, e.g. 2h. The job is aborted when exceeded. Disabled when 0.")
+	flag.BoolVar(&cfg.DeleteIntermediate, "delete-intermediate", false, "Delete intermediate mapper output after the job succeeded.")
+	flag.StringVar(&cfg.HttpAddr, "http-addr", "", "Address to serve /metrics on, e.g. :9090. Disabled when empty.")
+	flag.StringVar(&cfg.LogLevel, "log-level", "info", "Log level: debug, info, warn or error.")
+	flag.StringVar(&cfg.LogFormat, "log-format", "text", "Log format: text or json.")
+	flag.IntVar(&cfg.WorkerMetricsPort, "worker-metrics-port", 0, "Port mapper and reducer pods serve /metrics on. Disabled when 0.")
+
+	// Task pod scheduling flags
+	flag.StringVar(&cfg.MapperResources.CPURequest, "mapper-cpu-request", "", "CPU request of mapper pods, e.g. 500m.")
+	flag.StringVar(&cfg.MapperResources.MemoryRequest, "mapper-memory-request", "", "Memory request of mapper pods, e.g. 1Gi.")
+	flag.StringVar(&cfg.MapperResources.CPULimit, "mapper-cpu-limit", "", "CPU limit of mapper pods.")
+	flag.StringVar(&cfg.MapperResources.MemoryLimit, "mapper-memory-limit", "", "Memory limit of mapper pods.")
+	flag.StringVar(&cfg.ReducerResources.CPURequest, "reducer-cpu-request", "", "CPU request of reducer pods, e.g. 500m.")
+	flag.StringVar(&cfg.ReducerResources.MemoryRequest, "reducer-memory-request", "", "Memory request of reducer pods, e.g. 1Gi.")
+	flag.StringVar(&cfg.ReducerResources.CPULimit, "reducer-cpu-limit", "", "CPU limit of reducer pods.")
+	flag.StringVar(&cfg.ReducerResources.MemoryLimit, "reducer-memory-limit", "", "Memory limit of reducer pods.")
+	flag.Var((*mapFlag)(&cfg.NodeSelector), "node-selector", "Node labels task pods must be scheduled on, e.g. disk=ssd,zone=a.")
+	flag.Var((*tolerationsFlag)(&cfg.Tolerations), "toleration", "Taint task pods tolerate in the format key[=value]:Effect. Can be repeated.")
+	flag.BoolVar(&cfg.SpreadTasks, "spread-tasks", false, "Prefer scheduling the tasks of a job on different nodes.")
+	flag.StringVar(&cfg.PriorityClassName, "priority-class", "", "Priority class of task pods.")
+
+	// Task pod flags
+	flag.StringVar(&cfg.Namespace, "namespace", "default", "Namespace to run the Kubernetes Jobs of tasks in.")
+	flag.StringVar(&cfg.NfsVolume, "nfs-volume", "nfs-storage", "Name of the shared volume in task pods.")
+	flag.StringVar(&cfg.NfsClaim, "nfs-claim", "nfs-pvc", "PersistentVolumeClaim of the shared volume mounted at --nfs-path.")
+	flag.StringVar(&cfg.NfsSubPath, "nfs-sub-path", "", "Directory of the shared volume to mount instead of its root.")
+	flag.Var((*envFlag)(&cfg.Env), "env", "Environment variable of task pods in the format KEY=VALUE. Can be repeated.")
+	flag.Var((*envSecretFlag)(&cfg.EnvFrom), "env-secret", "Secret whose keys are added as environment variables to task pods. Can be repeated.")
+	flag.Var(volumesFlag{&cfg.Volumes, &cfg.VolumeMounts}, "volume", "Volume mounted in task pods in the format kind:name:/path, where kind is secret, configmap or pvc. Can be repeated.")
 
 	// Mapper and reducer flags
 	flag.StringVar(&cfg.NfsPath, "nfs-path", "/mnt/nfs", "Base directory where nfs is mounted.")
 	flag.StringVar(&cfg.FileRange, "file-range", "", "File ranges of files to be processed. Expected format `prefix-start-end`")
 	flag.IntVar(&cfg.ReducerId, "reducer-id", 0, "Reducer id.")
+	flag.StringVar(&cfg.JobId, "job-id", "", "Id of the job the task belongs to. Task counters are only persisted when set.")
+	flag.StringVar(&cfg.TaskId, "task-id", "", "Id of the task within the job, e.g. mapper-0.")
+	flag.IntVar(&cfg.Attempt, "attempt", 0, "Attempt number of the task.")
 	flag.Parse()
 	return cfg
 }
+
+// RegisterMapper makes a mapper available to jobs under name.
+func (cfg *Config) RegisterMapper(name string, mapper interfaces.Mapper) {
+	if cfg.Mappers == nil {
+		cfg.Mappers = make(map[string]interfaces.Mapper)
+	}
+	cfg.Mappers[name] = mapper
+}
+
+// RegisterReducer makes a reducer available to jobs under name.
+func (cfg *Config) RegisterReducer(name string, reducer interfaces.Reducer) {
+	if cfg.Reducers == nil {
+		cfg.Reducers = make(map[string]interfaces.Reducer)
+	}
+	cfg.Reducers[name] = reducer
+}
+
+// RegisterKeyedReducer makes a keyed reducer available to jobs under name.
+// Reducers and keyed reducers share the names selected by ReducerName.
+func (cfg *Config) RegisterKeyedReducer(name string, reducer interfaces.KeyedReducer) {
+	if cfg.KeyedReducers == nil {
+		cfg.KeyedReducers = make(map[string]interfaces.KeyedReducer)
+	}
+	cfg.KeyedReducers[name] = reducer
+}
+
+// HasReducer reports whether a reducer or keyed reducer is registered under
+// name.
+func (cfg *Config) HasReducer(name string) bool {
+	_, ok := cfg.Reducers[name]
+	_, keyed := cfg.KeyedReducers[name]
+	return ok || keyed
+}
+
+// RegisterOutputFormat makes a custom output format available to jobs under
+// name. It takes precedence over a built-in format of the same name.
+func (cfg *Config) RegisterOutputFormat(name string, format interfaces.OutputFormat) {
+	if cfg.OutputFormats == nil {
+		cfg.OutputFormats = make(map[string]interfaces.OutputFormat)
+	}
+	cfg.OutputFormats[name] = format
+}
+
+// LookupOutputFormat returns the registered or built-in output format with
+// the given name.
+func (cfg *Config) LookupOutputFormat(name string) (interfaces.OutputFormat, bool) {
+	if format, ok := cfg.OutputFormats[name]; ok {
+		return format, true
+	}
+	return output.Lookup(name)
+}
+
+// RegisterInputFormat makes a custom input format available to jobs under
+// name. It takes precedence over a built-in format of the same name.
+func (cfg *Config) RegisterInputFormat(name string, format interfaces.InputFormat) {
+	if cfg.InputFormats == nil {
+		cfg.InputFormats = make(map[string]interfaces.InputFormat)
+	}
+	cfg.InputFormats[name] = format
+}
+
+// LookupInputFormat returns the registered or built-in input format with the
+// given name.
+func (cfg *Config) LookupInputFormat(name string) (interfaces.InputFormat, bool) {
+	if format, ok := cfg.InputFormats[name]; ok {
+		return format, true
+	}
+	return input.Lookup(name)
+}
+
+// SelectFunctions sets Mapper and Reducer, or KeyedReducer, to the registered
+// functions named by MapperName and ReducerName, if set, and InputFormat and
+// OutputFormat to the formats named by InputFormatName and OutputFormatName.
+func (cfg *Config) SelectFunctions() error {
+	if cfg.MapperName != "" {
+		mapper, ok := cfg.Mappers[cfg.MapperName]
+		if !ok {
+			return fmt.Errorf("unknown mapper %q", cfg.MapperName)
+		}
+		cfg.Mapper = mapper
+	}
+	if reducer, ok := cfg.KeyedReducers[cfg.ReducerName]; ok && cfg.ReducerName != "" {
+		cfg.KeyedReducer = reducer
+		cfg.Reducer = nil
+	} else if cfg.ReducerName != "" {
+		reducer, ok := cfg.Reducers[cfg.ReducerName]
+		if !ok {
+			return fmt.Errorf("unknown reducer %q", cfg.ReducerName)
+		}
+		cfg.Reducer = reducer
+		cfg.KeyedReducer = nil
+	}
+	if cfg.OutputFormatName == "" {
+		cfg.OutputFormatName = output.Text
+	}
+	format, ok := cfg.LookupOutputFormat(cfg.OutputFormatName)
+	if !ok {
+		return fmt.Errorf("unknown output format %q", cfg.OutputFormatName)
+	}
+	cfg.OutputFormat = format
+
+	if cfg.InputFormatName == "" {
+		cfg.InputFormatName = input.Text
+	}
+	inputFormat, ok := cfg.LookupInputFormat(cfg.InputFormatName)
+	if !ok {
+		return fmt.Errorf("unknown input format %q", cfg.InputFormatName)
+	}
+	cfg.InputFormat = inputFormat
+	return nil
+}
+
+// JobDir returns the directory on the shared volume holding all job data.
+func (cfg *Config) JobDir() string {
+	return filepath.Join(cfg.NfsPath, cfg.JobId)
+}
+
+// defaultKubeconfig returns ~/.kube/config, or an empty path when there is no
+// home directory.
+func defaultKubeconfig() string {
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
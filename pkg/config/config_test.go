@@ -0,0 +1,82 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/input"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+)
+
+type customFormat struct{ output.TextFormat }
+
+func TestSelectOutputFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    interfaces.OutputFormat
+		wantErr bool
+	}{
+		{name: "", want: output.TextFormat{}},
+		{name: "csv", want: output.CSVFormat{}},
+		{name: "custom", want: customFormat{}},
+		{name: "parquet", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{OutputFormatName: tt.name}
+		cfg.RegisterOutputFormat("custom", customFormat{})
+		err := cfg.SelectFunctions()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && cfg.OutputFormat != tt.want {
+			t.Errorf("%q: format = %#v, want %#v", tt.name, cfg.OutputFormat, tt.want)
+		}
+	}
+}
+
+func TestSelectInputFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    interfaces.InputFormat
+		wantErr bool
+	}{
+		{name: "", want: input.TextFormat{}},
+		{name: "csv", want: input.CSVFormat{}},
+		{name: "parquet", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{InputFormatName: tt.name}
+		err := cfg.SelectFunctions()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && cfg.InputFormat != tt.want {
+			t.Errorf("%q: format = %#v, want %#v", tt.name, cfg.InputFormat, tt.want)
+		}
+	}
+}
+
+type keyedReducer struct{}
+
+func (keyedReducer) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {}
+
+type valueReducer struct{}
+
+func (valueReducer) Reduce(input interfaces.ReducerInput, emit func(value string)) {}
+
+func TestSelectKeyedReducer(t *testing.T) {
+	cfg := &Config{Reducer: valueReducer{}, ReducerName: "index"}
+	cfg.RegisterReducer("sum", valueReducer{})
+	cfg.RegisterKeyedReducer("index", keyedReducer{})
+	if err := cfg.SelectFunctions(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeyedReducer != (keyedReducer{}) || cfg.Reducer != nil {
+		t.Errorf("reducer = %v, keyed reducer = %v", cfg.Reducer, cfg.KeyedReducer)
+	}
+	if !cfg.HasReducer("sum") || !cfg.HasReducer("index") || cfg.HasReducer("top") {
+		t.Error("unexpected registered reducers")
+	}
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// mapFlag parses comma separated key=value pairs, e.g. disk=ssd,zone=a.
+type mapFlag map[string]string
+
+func (m *mapFlag) String() string {
+	pairs := make([]string, 0, len(*m))
+	for key, value := range *m {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapFlag) Set(value string) error {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key=value but got %q", pair)
+		}
+		(*m)[key] = val
+	}
+	return nil
+}
+
+// tolerationsFlag parses tolerations in the kubectl taint format
+// key[=value]:Effect. It can be repeated.
+type tolerationsFlag []v1.Toleration
+
+func (t *tolerationsFlag) String() string {
+	parts := make([]string, 0, len(*t))
+	for _, toleration := range *t {
+		parts = append(parts, fmt.Sprintf("%s=%s:%s", toleration.Key, toleration.Value, toleration.Effect))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (t *tolerationsFlag) Set(value string) error {
+	toleration, err := ParseToleration(value)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, toleration)
+	return nil
+}
+
+// ParseToleration parses a toleration in the format key[=value]:Effect. A
+// toleration without a value tolerates any value of the key.
+func ParseToleration(value string) (v1.Toleration, error) {
+	keyValue, effect, ok := strings.Cut(value, ":")
+	if !ok {
+		return v1.Toleration{}, fmt.Errorf("expected key[=value]:Effect but got %q", value)
+	}
+	switch v1.TaintEffect(effect) {
+	case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return v1.Toleration{}, fmt.Errorf("invalid taint effect %q", effect)
+	}
+	key, val, hasValue := strings.Cut(keyValue, "=")
+	if key == "" {
+		return v1.Toleration{}, fmt.Errorf("missing toleration key in %q", value)
+	}
+	toleration := v1.Toleration{Key: key, Effect: v1.TaintEffect(effect), Operator: v1.TolerationOpExists}
+	if hasValue {
+		toleration.Operator = v1.TolerationOpEqual
+		toleration.Value = val
+	}
+	return toleration, nil
+}
+
+// envFlag parses environment variables in the format KEY=VALUE. It can be
+// repeated.
+type envFlag []v1.EnvVar
+
+func (e *envFlag) String() string {
+	parts := make([]string, 0, len(*e))
+	for _, env := range *e {
+		parts = append(parts, env.Name+"="+env.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (e *envFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected KEY=VALUE but got %q", value)
+	}
+	*e = append(*e, v1.EnvVar{Name: name, Value: val})
+	return nil
+}
+
+// envSecretFlag adds all keys of a secret as environment variables. It can
+// be repeated.
+type envSecretFlag []v1.EnvFromSource
+
+func (e *envSecretFlag) String() string {
+	names := make([]string, 0, len(*e))
+	for _, source := range *e {
+		if source.SecretRef != nil {
+			names = append(names, source.SecretRef.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func (e *envSecretFlag) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("missing secret name")
+	}
+	*e = append(*e, v1.EnvFromSource{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: value}}})
+	return nil
+}
+
+// volumesFlag parses volumes in the format kind:name:path and mounts them in
+// task pods. It can be repeated.
+type volumesFlag struct {
+	volumes *[]v1.Volume
+	mounts  *[]v1.VolumeMount
+}
+
+func (f volumesFlag) String() string {
+	if f.volumes == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*f.volumes))
+	for i, volume := range *f.volumes {
+		if part, ok := FormatVolume(volume, (*f.mounts)[i]); ok {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f volumesFlag) Set(value string) error {
+	volume, mount, err := ParseVolume(value)
+	if err != nil {
+		return err
+	}
+	*f.volumes = append(*f.volumes, volume)
+	*f.mounts = append(*f.mounts, mount)
+	return nil
+}
+
+// Kinds of volumes that can be given as flags.
+const (
+	VolumeSecret    = "secret"
+	VolumeConfigMap = "configmap"
+	VolumeClaim     = "pvc"
+)
+
+// ParseVolume parses a volume in the format kind:name:path, where kind is
+// secret, configmap or pvc, name is the name of the secret, ConfigMap or
+// PersistentVolumeClaim, and path is where it is mounted. Secrets and
+// ConfigMaps are mounted read-only.
+func ParseVolume(value string) (v1.Volume, v1.VolumeMount, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[1] == "" || !strings.HasPrefix(parts[2], "/") {
+		return v1.Volume{}, v1.VolumeMount{}, fmt.Errorf("expected kind:name:/path but got %q", value)
+	}
+	kind, name, path := parts[0], parts[1], parts[2]
+	volume := v1.Volume{Name: kind + "-" + name}
+	mount := v1.VolumeMount{Name: volume.Name, MountPath: path}
+	switch kind {
+	case VolumeSecret:
+		volume.Secret = &v1.SecretVolumeSource{SecretName: name}
+		mount.ReadOnly = true
+	case VolumeConfigMap:
+		volume.ConfigMap = &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: name}}
+		mount.ReadOnly = true
+	case VolumeClaim:
+		volume.PersistentVolumeClaim = &v1.PersistentVolumeClaimVolumeSource{ClaimName: name}
+	default:
+		return v1.Volume{}, v1.VolumeMount{}, fmt.Errorf("invalid volume kind %q, expected secret, configmap or pvc", kind)
+	}
+	return volume, mount, nil
+}
+
+// FormatVolume returns the flag value for a volume and its mount. It reports
+// false for volumes that cannot be expressed as a flag.
+func FormatVolume(volume v1.Volume, mount v1.VolumeMount) (string, bool) {
+	switch {
+	case volume.Secret != nil:
+		return fmt.Sprintf("%s:%s:%s", VolumeSecret, volume.Secret.SecretName, mount.MountPath), true
+	case volume.ConfigMap != nil:
+		return fmt.Sprintf("%s:%s:%s", VolumeConfigMap, volume.ConfigMap.Name, mount.MountPath), true
+	case volume.PersistentVolumeClaim != nil:
+		return fmt.Sprintf("%s:%s:%s", VolumeClaim, volume.PersistentVolumeClaim.ClaimName, mount.MountPath), true
+	}
+	return "", false
+}
+
+// inputsFlag parses tagged inputs in the format
+// tag=name,mapper=name,format=name,dir=path. It can be repeated.
+type inputsFlag []Input
+
+func (f *inputsFlag) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, input := range *f {
+		parts = append(parts, FormatInput(input))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *inputsFlag) Set(value string) error {
+	input, err := ParseInput(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, input)
+	return nil
+}
+
+// ParseInput parses a tagged input in the format
+// tag=name,mapper=name,format=name,dir=path. The mapper and the input format
+// are optional and default to those of the job.
+func ParseInput(value string) (Input, error) {
+	var input Input
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok || val == "" {
+			return Input{}, fmt.Errorf("expected key=value but got %q in input %q", field, value)
+		}
+		switch key {
+		case "tag":
+			input.Tag = val
+		case "mapper":
+			input.Mapper = val
+		case "format":
+			input.Format = val
+		case "dir":
+			input.Dir = val
+		default:
+			return Input{}, fmt.Errorf("unknown field %q in input %q", key, value)
+		}
+	}
+	if input.Tag == "" || input.Dir == "" {
+		return Input{}, fmt.Errorf("input %q must have a tag and a dir", value)
+	}
+	return input, nil
+}
+
+// FormatInput formats a tagged input for ParseInput.
+func FormatInput(input Input) string {
+	value := "tag=" + input.Tag
+	if input.Mapper != "" {
+		value += ",mapper=" + input.Mapper
+	}
+	if input.Format != "" {
+		value += ",format=" + input.Format
+	}
+	return value + ",dir=" + input.Dir
+}
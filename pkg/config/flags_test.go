@@ -0,0 +1,135 @@
+package config
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    v1.Toleration
+		wantErr bool
+	}{
+		{
+			value: "dedicated=batch:NoSchedule",
+			want:  v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "batch", Effect: v1.TaintEffectNoSchedule},
+		},
+		{
+			value: "spot:NoExecute",
+			want:  v1.Toleration{Key: "spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+		},
+		{value: "dedicated=batch", wantErr: true},
+		{value: "dedicated=batch:Sometimes", wantErr: true},
+		{value: "=batch:NoSchedule", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseToleration(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseToleration(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseToleration(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMapFlag(t *testing.T) {
+	var m mapFlag
+	if err := m.Set("disk=ssd,zone=a"); err != nil {
+		t.Fatal(err)
+	}
+	if m["disk"] != "ssd" || m["zone"] != "a" {
+		t.Errorf("unexpected map %v", m)
+	}
+	if err := m.Set("nokey"); err == nil {
+		t.Error("expected an error for a pair without =")
+	}
+}
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantMount v1.VolumeMount
+		wantErr   bool
+	}{
+		{value: "secret:api-keys:/etc/api-keys", wantMount: v1.VolumeMount{Name: "secret-api-keys", MountPath: "/etc/api-keys", ReadOnly: true}},
+		{value: "configmap:stopwords:/etc/stopwords", wantMount: v1.VolumeMount{Name: "configmap-stopwords", MountPath: "/etc/stopwords", ReadOnly: true}},
+		{value: "pvc:scratch:/scratch", wantMount: v1.VolumeMount{Name: "pvc-scratch", MountPath: "/scratch"}},
+		{value: "secret:api-keys", wantErr: true},
+		{value: "secret:api-keys:relative", wantErr: true},
+		{value: "hostpath:tmp:/tmp", wantErr: true},
+	}
+	for _, tt := range tests {
+		volume, mount, err := ParseVolume(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseVolume(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if mount != tt.wantMount || volume.Name != mount.Name {
+			t.Errorf("ParseVolume(%q) = %+v, %+v", tt.value, volume, mount)
+		}
+		if got, ok := FormatVolume(volume, mount); !ok || got != tt.value {
+			t.Errorf("FormatVolume(ParseVolume(%q)) = %q", tt.value, got)
+		}
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Input
+		wantErr bool
+	}{
+		{value: "tag=users,mapper=users,dir=/mnt/nfs/users", want: Input{Tag: "users", Mapper: "users", Dir: "/mnt/nfs/users"}},
+		{value: "dir=/mnt/nfs/orders,tag=orders", want: Input{Tag: "orders", Dir: "/mnt/nfs/orders"}},
+		{value: "tag=users,format=csv,dir=/mnt/nfs/users", want: Input{Tag: "users", Format: "csv", Dir: "/mnt/nfs/users"}},
+		{value: "tag=users", wantErr: true},
+		{value: "tag=users,dir=/in,kind=csv", wantErr: true},
+		{value: "tag=,dir=/in", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseInput(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseInput(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseInput(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+		if !tt.wantErr {
+			if again, err := ParseInput(FormatInput(got)); err != nil || again != got {
+				t.Errorf("FormatInput(%+v) does not round trip: %+v, %v", got, again, err)
+			}
+		}
+	}
+}
+
+func TestEnvFlags(t *testing.T) {
+	var env envFlag
+	if err := env.Set("REGION=eu-west-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Set("EMPTY="); err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 2 || env[0] != (v1.EnvVar{Name: "REGION", Value: "eu-west-1"}) || env[1].Name != "EMPTY" {
+		t.Errorf("unexpected env %v", env)
+	}
+	if err := env.Set("REGION"); err == nil {
+		t.Error("expected an error for a variable without =")
+	}
+
+	var secrets envSecretFlag
+	if err := secrets.Set("api-keys"); err != nil {
+		t.Fatal(err)
+	}
+	if secrets.String() != "api-keys" {
+		t.Errorf("unexpected secrets %q", secrets.String())
+	}
+}
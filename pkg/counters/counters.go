@@ -0,0 +1,160 @@
+package counters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Built-in counters maintained by the framework.
+const (
+	MapInputRecords     = "map.input_records"
+	MapOutputPairs      = "map.output_pairs"
+	MapBytesWritten     = "map.bytes_written"
+	ReduceInputGroups   = "reduce.input_groups"
+	ReduceInputRecords  = "reduce.input_records"
+	ReduceSkippedValues = "reduce.skipped_values"
+	ReduceOutputRecords = "reduce.output_records"
+	ReduceBytesWritten  = "reduce.bytes_written"
+)
+
+// PartitionBytesWritten returns the name of the counter tracking bytes a
+// mapper wrote to the given partition.
+func PartitionBytesWritten(partition int) string {
+	return fmt.Sprintf("map.partition_%d.bytes_written", partition)
+}
+
+// NamedOutputRecords returns the name of the counter tracking records a task
+// wrote to the named output.
+func NamedOutputRecords(name string) string {
+	return fmt.Sprintf("output.%s.records", name)
+}
+
+// Counters is a set of named counters that is safe for concurrent use.
+type Counters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func New() *Counters {
+	return &Counters{values: make(map[string]int64)}
+}
+
+func (c *Counters) Add(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] += delta
+}
+
+func (c *Counters) Inc(name string) {
+	c.Add(name, 1)
+}
+
+func (c *Counters) Get(name string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name]
+}
+
+// Snapshot returns a copy of the current counter values.
+func (c *Counters) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]int64, len(c.values))
+	for name, value := range c.values {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// Merge adds all values to the counters.
+func (c *Counters) Merge(values map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, value := range values {
+		c.values[name] += value
+	}
+}
+
+// WriteFile persists the counters as JSON.
+func (c *Counters) WriteFile(path string) error {
+	data, err := json.MarshalIndent(c.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Save persists the counters of task taskId to the directory of its job,
+// where the master aggregates them. Tasks run outside a job, i.e. with an
+// empty jobId, log them instead.
+func Save(c *Counters, jobId, jobDir, taskId string) error {
+	if jobId == "" {
+		slog.Info("Counters", "counters", c.Snapshot())
+		return nil
+	}
+	return c.WriteFile(TaskPath(jobDir, taskId))
+}
+
+func ReadFile(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("parsing counters %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Dir returns the directory inside a job directory where tasks persist their counters.
+func Dir(jobDir string) string {
+	return filepath.Join(jobDir, "counters")
+}
+
+// TaskPath returns the file a task persists its counters to.
+func TaskPath(jobDir, taskId string) string {
+	return filepath.Join(Dir(jobDir), taskId+".json")
+}
+
+// Aggregate sums the counters persisted by all tasks of a job.
+func Aggregate(jobDir string) (*Counters, error) {
+	entries, err := os.ReadDir(Dir(jobDir))
+	if err != nil {
+		return nil, err
+	}
+	total := New()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		values, err := ReadFile(filepath.Join(Dir(jobDir), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		total.Merge(values)
+	}
+	return total, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the counters.
+func NewContext(ctx context.Context, c *Counters) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the task counters stored in ctx. If there are none, a
+// fresh set is returned so that callers never have to check for nil.
+func FromContext(ctx context.Context) *Counters {
+	if c, ok := ctx.Value(contextKey{}).(*Counters); ok {
+		return c
+	}
+	return New()
+}
@@ -0,0 +1,72 @@
+package counters
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	jobDir := t.TempDir()
+	if err := os.Mkdir(Dir(jobDir), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := New()
+	mapper.Add(MapInputRecords, 10)
+	mapper.Inc("malformed")
+	if err := mapper.WriteFile(TaskPath(jobDir, "mapper-0")); err != nil {
+		t.Fatal(err)
+	}
+	other := New()
+	other.Add(MapInputRecords, 5)
+	if err := other.WriteFile(TaskPath(jobDir, "mapper-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := Aggregate(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := total.Get(MapInputRecords); got != 15 {
+		t.Errorf("%s = %d, want 15", MapInputRecords, got)
+	}
+	if got := total.Get("malformed"); got != 1 {
+		t.Errorf("malformed = %d, want 1", got)
+	}
+}
+
+func TestSave(t *testing.T) {
+	jobDir := t.TempDir()
+	if err := os.Mkdir(Dir(jobDir), 0777); err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	c.Inc("malformed")
+	if err := Save(c, "job", jobDir, "reducer-0"); err != nil {
+		t.Fatal(err)
+	}
+	values, err := ReadFile(TaskPath(jobDir, "reducer-0"))
+	if err != nil || values["malformed"] != 1 {
+		t.Errorf("saved %v, %v", values, err)
+	}
+	// Counters of tasks outside a job are only logged.
+	if err := Save(c, "", jobDir, "mapper-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(TaskPath(jobDir, "mapper-0")); !os.IsNotExist(err) {
+		t.Errorf("counters of a task outside a job were saved: %v", err)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	c := New()
+	ctx := NewContext(context.Background(), c)
+	FromContext(ctx).Inc("lines")
+	if got := c.Get("lines"); got != 1 {
+		t.Errorf("lines = %d, want 1", got)
+	}
+
+	// Counters missing from the context must still be usable.
+	FromContext(context.Background()).Inc("lines")
+}
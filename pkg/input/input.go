@@ -0,0 +1,163 @@
+// Package input implements the built-in formats of job input files. Every
+// output format has an input format of the same name that reads it back.
+package input
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+)
+
+// Names of the built-in formats.
+const (
+	Text     = output.Text
+	JSONL    = output.JSONL
+	CSV      = output.CSV
+	TSV      = output.TSV
+	Sequence = output.Sequence
+)
+
+var formats = map[string]interfaces.InputFormat{
+	Text:     TextFormat{},
+	JSONL:    JSONLFormat{},
+	CSV:      CSVFormat{},
+	TSV:      TSVFormat{},
+	Sequence: SequenceFormat{},
+}
+
+// Lookup returns the built-in format with the given name.
+func Lookup(name string) (interfaces.InputFormat, bool) {
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Names returns the names of the built-in formats.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TextFormat reads every line as a record with a single field. It is the
+// default format.
+type TextFormat struct{}
+
+func (TextFormat) NewRecordReader(r io.Reader) (interfaces.RecordReader, error) {
+	return &lineReader{scanner: bufio.NewScanner(r), parse: func(line string) ([]string, error) {
+		return []string{line}, nil
+	}}, nil
+}
+
+// JSONLFormat reads every non-empty line as a record with a single field
+// holding a JSON value. Mappers decode it themselves, e.g. into output.Record
+// for the output of another job.
+type JSONLFormat struct{}
+
+func (JSONLFormat) NewRecordReader(r io.Reader) (interfaces.RecordReader, error) {
+	return &lineReader{scanner: bufio.NewScanner(r), skipEmpty: true, parse: func(line string) ([]string, error) {
+		if !json.Valid([]byte(line)) {
+			return nil, fmt.Errorf("invalid JSON %q", line)
+		}
+		return []string{line}, nil
+	}}, nil
+}
+
+// TSVFormat reads tab separated lines and unescapes the fields like
+// output.TSVFormat escapes them.
+type TSVFormat struct{}
+
+var tsvUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+func (TSVFormat) NewRecordReader(r io.Reader) (interfaces.RecordReader, error) {
+	return &lineReader{scanner: bufio.NewScanner(r), parse: func(line string) ([]string, error) {
+		fields := strings.Split(line, "\t")
+		for i, field := range fields {
+			fields[i] = tsvUnescaper.Replace(field)
+		}
+		return fields, nil
+	}}, nil
+}
+
+type lineReader struct {
+	scanner   *bufio.Scanner
+	skipEmpty bool
+	parse     func(line string) ([]string, error)
+	line      int
+}
+
+func (lr *lineReader) Read() ([]string, error) {
+	for lr.scanner.Scan() {
+		lr.line++
+		if lr.skipEmpty && strings.TrimSpace(lr.scanner.Text()) == "" {
+			continue
+		}
+		fields, err := lr.parse(lr.scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lr.line, err)
+		}
+		return fields, nil
+	}
+	if err := lr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// CSVFormat reads RFC 4180 rows. Rows may have different numbers of fields.
+type CSVFormat struct{}
+
+func (CSVFormat) NewRecordReader(r io.Reader) (interfaces.RecordReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return reader, nil
+}
+
+// SequenceFormat reads files written by output.SequenceFormat as records with
+// a key and a value field.
+type SequenceFormat struct{}
+
+func (SequenceFormat) NewRecordReader(r io.Reader) (interfaces.RecordReader, error) {
+	reader, err := output.NewSequenceReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return sequenceReader{reader}, nil
+}
+
+type sequenceReader struct {
+	r *output.SequenceReader
+}
+
+func (sr sequenceReader) Read() ([]string, error) {
+	key, value, err := sr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return []string{key, value}, nil
+}
+
+type tagContextKey struct{}
+
+// NewTagContext returns a copy of ctx carrying the tag of the input a mapper
+// reads.
+func NewTagContext(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagContextKey{}, tag)
+}
+
+// TagFromContext returns the tag of the input a mapper reads, or "" for jobs
+// without tagged inputs.
+func TagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(tagContextKey{}).(string)
+	return tag
+}
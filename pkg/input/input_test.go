@@ -0,0 +1,111 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/output"
+)
+
+var records = [][2]string{
+	{"plain", "1"},
+	{"comma,key", `quoted "value"`},
+	{"tab\tkey", "multi\nline\\"},
+}
+
+func readAll(t *testing.T, name string, data []byte) [][]string {
+	t.Helper()
+	format, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("format %q not found", name)
+	}
+	r, err := format.NewRecordReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, fields)
+	}
+}
+
+// TestReadsOutputFormats checks that every format reads back the key and
+// value written by the output format of the same name.
+func TestReadsOutputFormats(t *testing.T) {
+	for _, name := range []string{CSV, TSV, Sequence, JSONL} {
+		format, _ := output.Lookup(name)
+		var buf bytes.Buffer
+		w := format.NewRecordWriter(&buf)
+		for _, record := range records {
+			if err := w.Write(record[0], record[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		rows := readAll(t, name, buf.Bytes())
+		if len(rows) != len(records) {
+			t.Fatalf("%s: read %d records, want %d", name, len(rows), len(records))
+		}
+		for i, fields := range rows {
+			if name == JSONL {
+				var record output.Record
+				if err := json.Unmarshal([]byte(fields[0]), &record); err != nil {
+					t.Fatal(err)
+				}
+				fields = []string{record.Key, record.Value}
+			}
+			if !slices.Equal(fields, records[i][:]) {
+				t.Errorf("%s: record %d = %q, want %q", name, i, fields, records[i])
+			}
+		}
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	got := readAll(t, Text, []byte("a,1\n\nb c\n"))
+	want := [][]string{{"a,1"}, {""}, {"b c"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		data   string
+		errMsg string
+	}{
+		{JSONL, "{\"key\":\"a\"}\n\n{broken\n", "line 3"},
+		{CSV, "a,\"unterminated\n", "quote"},
+	} {
+		format, _ := Lookup(tc.format)
+		r, err := format.NewRecordReader(strings.NewReader(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			_, err = r.Read()
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.format, tc.errMsg, err)
+		}
+	}
+
+	if _, err := (SequenceFormat{}).NewRecordReader(strings.NewReader("a,1\n")); err == nil {
+		t.Error("expected an error for a text file read as sequence file")
+	}
+}
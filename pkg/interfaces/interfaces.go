@@ -1,20 +1,122 @@
 package interfaces
 
+import (
+	"context"
+	"io"
+	"iter"
+)
+
 type Mapper interface {
 	Map(input MapInput, emit func(string, string))
 }
 
+// Reducer reduces the values of a key. The values passed to emit are written
+// to the output under the input key.
 type Reducer interface {
 	Reduce(input ReducerInput, emit func(string))
 }
 
+// KeyedReducer is an alternative to Reducer for functions that choose the
+// output key, e.g. inverted indexes or top-N per key under a new key. Every
+// pair passed to emit is written to the output, in the order emitted.
+type KeyedReducer interface {
+	Reduce(input ReducerInput, emit func(key, value string))
+}
+
+// Partitioner is an optional interface for a Reducer or KeyedReducer that
+// assigns intermediate keys to reducers. Keys of the same group must be
+// assigned to the same reducer. Defaults to a hash of the key.
+type Partitioner interface {
+	Partition(key string, numPartitions int) int
+}
+
+// SortComparator is an optional interface for a Reducer or KeyedReducer that
+// orders intermediate keys, e.g. by user and then by timestamp for composite
+// keys. CompareKeys returns a negative number when a sorts before b, 0 when
+// they are equal and a positive number otherwise. Defaults to byte order.
+type SortComparator interface {
+	CompareKeys(a, b string) int
+}
+
+// GroupingComparator is an optional interface for a Reducer or KeyedReducer
+// that decides which consecutive sorted keys are passed to the same Reduce
+// call. CompareGroups returns 0 for keys of the same group and must agree
+// with the order of CompareKeys. Defaults to equal keys.
+type GroupingComparator interface {
+	CompareGroups(a, b string) int
+}
+
+// Setuper is an optional interface for a Mapper or Reducer. Setup is called
+// once per task before the first record is processed and is the place for
+// expensive initialization like compiling regexes or opening connections.
+type Setuper interface {
+	Setup(ctx context.Context) error
+}
+
+// Cleaner is an optional interface for a Mapper or Reducer. Cleanup is called
+// once per task after the last record has been processed. Pairs passed to emit
+// are written to the task output, which allows in-mapper combining.
+type Cleaner interface {
+	Cleanup(ctx context.Context, emit func(string, string)) error
+}
+
+// MapInput is a single input record. Context carries task scoped values like
+// counters.
 type MapInput interface {
 	Value() string
+	Context() context.Context
 }
 
+// TaggedInput is implemented by the MapInput of jobs with several tagged
+// inputs. Tag returns the tag of the input the record was read from.
+type TaggedInput interface {
+	MapInput
+	Tag() string
+}
+
+// FieldsInput is implemented by the MapInput of all input formats. Fields
+// returns the fields of the record, e.g. the columns of a CSV row, and Value
+// returns them joined by commas.
+type FieldsInput interface {
+	MapInput
+	Fields() []string
+}
+
+// ReducerInput iterates over the values grouped under a key, either with
+// Value, NextValue and Done or by ranging over Values. Values that Reduce
+// does not consume are skipped. Context carries task scoped values like
+// counters. Key returns the key of the current value, which changes within a
+// group when a GroupingComparator groups different keys.
 type ReducerInput interface {
+	Context() context.Context
 	Key() string
 	Value() string
 	NextValue()
 	Done() bool
+	Values() iter.Seq[string]
+}
+
+// InputFormat decodes the records of an input file, e.g. text lines or CSV
+// rows.
+type InputFormat interface {
+	NewRecordReader(r io.Reader) (RecordReader, error)
+}
+
+// RecordReader reads the records of an input file. Read returns the fields of
+// the next record and io.EOF after the last record.
+type RecordReader interface {
+	Read() ([]string, error)
+}
+
+// OutputFormat encodes the key/value records of a job's final output, e.g. as
+// text or JSON Lines.
+type OutputFormat interface {
+	NewRecordWriter(w io.Writer) RecordWriter
+}
+
+// RecordWriter writes records to an output file. Flush is called once after
+// the last record.
+type RecordWriter interface {
+	Write(key, value string) error
+	Flush() error
 }
@@ -0,0 +1,253 @@
+// Package join implements joins of tagged inputs. A reduce-side join wraps
+// the mapper of every input with Tagged and reduces with a Reducer. A
+// map-side broadcast join loads a small table into every mapper with
+// BroadcastMapper and needs no reducers.
+package join
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/input"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+// Counters of malformed records and values with tags other than the sides
+// of a Reducer.
+const (
+	MalformedRecords = "join.malformed_records"
+	UnknownTags      = "join.unknown_tags"
+)
+
+// separator separates the tag from the value of an intermediate value.
+const separator = "\x1f"
+
+// Encode prefixes value with the tag of its input.
+func Encode(tag, value string) string {
+	return tag + separator + value
+}
+
+// Decode splits a value created by Encode into its tag and value.
+func Decode(encoded string) (tag, value string, ok bool) {
+	return strings.Cut(encoded, separator)
+}
+
+// Type is the type of a join.
+type Type int
+
+const (
+	// Inner joins emit keys present in both inputs.
+	Inner Type = iota
+	// LeftOuter joins also emit keys present only in the left input.
+	LeftOuter
+	// FullOuter joins also emit keys present only in one of the inputs.
+	FullOuter
+)
+
+// Combine builds the value of a joined record. left or right is nil when the
+// key is missing from that input in an outer join.
+type Combine func(key string, left, right *string) string
+
+// DefaultCombine joins both values with a comma. Missing values are empty.
+func DefaultCombine(key string, left, right *string) string {
+	var l, r string
+	if left != nil {
+		l = *left
+	}
+	if right != nil {
+		r = *right
+	}
+	return l + "," + r
+}
+
+// Tagged wraps the mapper of an input of a reduce-side join. It encodes every
+// value emitted by m with the tag of the input the task reads, which Setup
+// takes from the context.
+func Tagged(m interfaces.Mapper) interfaces.Mapper {
+	return &taggedMapper{mapper: m}
+}
+
+type taggedMapper struct {
+	mapper interfaces.Mapper
+	tag    string
+}
+
+func (tm *taggedMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	tm.mapper.Map(input, func(key, value string) {
+		emit(key, Encode(tm.tag, value))
+	})
+}
+
+func (tm *taggedMapper) Setup(ctx context.Context) error {
+	tm.tag = input.TagFromContext(ctx)
+	if s, ok := tm.mapper.(interfaces.Setuper); ok {
+		return s.Setup(ctx)
+	}
+	return nil
+}
+
+func (tm *taggedMapper) Cleanup(ctx context.Context, emit func(key, value string)) error {
+	if cl, ok := tm.mapper.(interfaces.Cleaner); ok {
+		return cl.Cleanup(ctx, func(key, value string) {
+			emit(key, Encode(tm.tag, value))
+		})
+	}
+	return nil
+}
+
+// Reducer is a KeyedReducer that joins the values tagged Left with the values
+// tagged Right under every key. All values of a key are buffered, so one side
+// should have few values per key.
+type Reducer struct {
+	Type        Type
+	Left, Right string
+	// Combine defaults to DefaultCombine.
+	Combine Combine
+}
+
+func (r *Reducer) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {
+	c := counters.FromContext(input.Context())
+	key := input.Key()
+	var left, right []string
+	for encoded := range input.Values() {
+		tag, value, ok := Decode(encoded)
+		switch {
+		case !ok:
+			c.Inc(MalformedRecords)
+		case tag == r.Left:
+			left = append(left, value)
+		case tag == r.Right:
+			right = append(right, value)
+		default:
+			c.Inc(UnknownTags)
+		}
+	}
+
+	combine := r.Combine
+	if combine == nil {
+		combine = DefaultCombine
+	}
+	for i := range left {
+		for j := range right {
+			emit(key, combine(key, &left[i], &right[j]))
+		}
+		if len(right) == 0 && r.Type != Inner {
+			emit(key, combine(key, &left[i], nil))
+		}
+	}
+	if len(left) == 0 && r.Type == FullOuter {
+		for j := range right {
+			emit(key, combine(key, nil, &right[j]))
+		}
+	}
+}
+
+// BroadcastMapper joins the records of its input with a small table that
+// every mapper loads into memory, so that the join needs no shuffle. It
+// supports inner and left outer joins, with the input on the left.
+type BroadcastMapper struct {
+	// Table is a file, or a directory of files, with key,value lines, e.g.
+	// the output of another job.
+	Table string
+	// Key extracts the join key and the value from an input record.
+	// Defaults to splitting key,value records at the first comma.
+	Key  func(record string) (key, value string, ok bool)
+	Type Type
+	// Combine defaults to DefaultCombine.
+	Combine Combine
+
+	table map[string][]string
+}
+
+func (bm *BroadcastMapper) Setup(ctx context.Context) error {
+	if bm.Type == FullOuter {
+		return errors.New("broadcast joins do not support full outer joins")
+	}
+	table, err := loadTable(bm.Table)
+	if err != nil {
+		return fmt.Errorf("loading join table: %w", err)
+	}
+	bm.table = table
+	return nil
+}
+
+func (bm *BroadcastMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	split := bm.Key
+	if split == nil {
+		split = splitRecord
+	}
+	key, value, ok := split(input.Value())
+	if !ok {
+		counters.FromContext(input.Context()).Inc(MalformedRecords)
+		return
+	}
+	combine := bm.Combine
+	if combine == nil {
+		combine = DefaultCombine
+	}
+	matches := bm.table[key]
+	for i := range matches {
+		emit(key, combine(key, &value, &matches[i]))
+	}
+	if len(matches) == 0 && bm.Type == LeftOuter {
+		emit(key, combine(key, &value, nil))
+	}
+}
+
+func splitRecord(record string) (key, value string, ok bool) {
+	return strings.Cut(record, ",")
+}
+
+// loadTable reads the key,value lines of path. Hidden files and files
+// starting with "_" in a directory are skipped, like job inputs.
+func loadTable(path string) (map[string][]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") {
+				continue
+			}
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+
+	table := make(map[string][]string)
+	for _, file := range files {
+		if err := readTableFile(file, table); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+func readTableFile(path string, table map[string][]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok := splitRecord(scanner.Text())
+		if !ok {
+			return fmt.Errorf("%s:%d: expected a key,value line", path, line)
+		}
+		table[key] = append(table[key], value)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,208 @@
+package join
+
+import (
+	"context"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/input"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+type testInput struct {
+	ctx   context.Context
+	value string
+	tag   string
+}
+
+func (ti *testInput) Value() string            { return ti.value }
+func (ti *testInput) Context() context.Context { return ti.ctx }
+func (ti *testInput) Tag() string              { return ti.tag }
+
+// sliceInput is a ReducerInput over the values of a single key.
+type sliceInput struct {
+	ctx    context.Context
+	key    string
+	values []string
+}
+
+func (si *sliceInput) Context() context.Context { return si.ctx }
+func (si *sliceInput) Key() string              { return si.key }
+func (si *sliceInput) Value() string            { return si.values[0] }
+func (si *sliceInput) NextValue()               { si.values = si.values[1:] }
+func (si *sliceInput) Done() bool               { return len(si.values) == 0 }
+
+func (si *sliceInput) Values() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for ; !si.Done(); si.NextValue() {
+			if !yield(si.Value()) {
+				return
+			}
+		}
+	}
+}
+
+type upperMapper struct {
+	setup bool
+}
+
+func (um *upperMapper) Setup(ctx context.Context) error {
+	um.setup = true
+	return nil
+}
+
+func (um *upperMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	key, value, _ := strings.Cut(input.Value(), ",")
+	emit(key, strings.ToUpper(value))
+}
+
+func (um *upperMapper) Cleanup(ctx context.Context, emit func(key, value string)) error {
+	emit("total", "1")
+	return nil
+}
+
+func TestTagged(t *testing.T) {
+	inner := &upperMapper{}
+	m := Tagged(inner)
+	ctx := input.NewTagContext(context.Background(), "users")
+	if err := m.(interfaces.Setuper).Setup(ctx); err != nil || !inner.setup {
+		t.Fatalf("setup was not forwarded: %v", err)
+	}
+
+	var got []string
+	emit := func(key, value string) {
+		tag, value, ok := Decode(value)
+		if !ok {
+			t.Errorf("value %q is not tagged", value)
+		}
+		got = append(got, key+"/"+tag+"/"+value)
+	}
+	m.Map(&testInput{ctx: ctx, value: "1,ann", tag: "users"}, emit)
+	if err := m.(interfaces.Cleaner).Cleanup(ctx, emit); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1/users/ANN", "total/users/1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestTaggedCleanupWithoutInput(t *testing.T) {
+	m := Tagged(&upperMapper{})
+	ctx := input.NewTagContext(context.Background(), "orders")
+	if err := m.(interfaces.Setuper).Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err := m.(interfaces.Cleaner).Cleanup(ctx, func(key, value string) {
+		got = append(got, key+"="+value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"total=" + Encode("orders", "1")}; !slices.Equal(got, want) {
+		t.Errorf("emitted %q, want %q", got, want)
+	}
+}
+
+func TestReducer(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		typ    Type
+		values []string
+		want   []string
+	}{
+		{"inner", Inner, []string{Encode("users", "ann"), Encode("orders", "o1"), Encode("orders", "o2")}, []string{"ann,o1", "ann,o2"}},
+		{"inner without match", Inner, []string{Encode("users", "ann")}, nil},
+		{"left outer", LeftOuter, []string{Encode("users", "ann")}, []string{"ann,"}},
+		{"left outer without left", LeftOuter, []string{Encode("orders", "o1")}, nil},
+		{"full outer", FullOuter, []string{Encode("orders", "o1")}, []string{",o1"}},
+		{"full outer with match", FullOuter, []string{Encode("orders", "o1"), Encode("users", "ann")}, []string{"ann,o1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Reducer{Type: tc.typ, Left: "users", Right: "orders"}
+			var got []string
+			r.Reduce(&sliceInput{ctx: context.Background(), key: "1", values: tc.values}, func(key, value string) {
+				if key != "1" {
+					t.Errorf("key = %q", key)
+				}
+				got = append(got, value)
+			})
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("emitted %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReducerCountsBadValues(t *testing.T) {
+	c := counters.New()
+	ctx := counters.NewContext(context.Background(), c)
+	r := &Reducer{Left: "users", Right: "orders", Combine: func(key string, left, right *string) string {
+		return key + ":" + *left + "+" + *right
+	}}
+	var got []string
+	values := []string{"untagged", Encode("other", "x"), Encode("users", "ann"), Encode("orders", "o1")}
+	r.Reduce(&sliceInput{ctx: ctx, key: "1", values: values}, func(key, value string) {
+		got = append(got, value)
+	})
+	if !slices.Equal(got, []string{"1:ann+o1"}) {
+		t.Errorf("emitted %q", got)
+	}
+	if c.Get(MalformedRecords) != 1 || c.Get(UnknownTags) != 1 {
+		t.Errorf("counters = %v", c.Snapshot())
+	}
+}
+
+func TestBroadcastMapper(t *testing.T) {
+	tableDir := t.TempDir()
+	for name, data := range map[string]string{
+		"reducer-0": "1,ann\n",
+		"reducer-1": "2,bob\n2,bobby\n",
+		"_SUCCESS":  "",
+	} {
+		if err := os.WriteFile(filepath.Join(tableDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		typ  Type
+		want []string
+	}{
+		{Inner, []string{"1:o1,ann", "2:o2,bob", "2:o2,bobby"}},
+		{LeftOuter, []string{"1:o1,ann", "2:o2,bob", "2:o2,bobby", "3:o3,"}},
+	} {
+		m := &BroadcastMapper{Table: tableDir, Type: tc.typ}
+		if err := m.Setup(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, record := range []string{"1,o1", "2,o2", "3,o3"} {
+			m.Map(&testInput{ctx: context.Background(), value: record}, func(key, value string) {
+				got = append(got, key+":"+value)
+			})
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("join type %d emitted %q, want %q", tc.typ, got, tc.want)
+		}
+	}
+}
+
+func TestBroadcastMapperSetupErrors(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(table, []byte("no comma\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&BroadcastMapper{Table: table}).Setup(context.Background()); err == nil || !strings.Contains(err.Error(), "users:1") {
+		t.Errorf("expected an error for a malformed table, got %v", err)
+	}
+	if err := (&BroadcastMapper{Table: table, Type: FullOuter}).Setup(context.Background()); err == nil {
+		t.Error("expected an error for a full outer join")
+	}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
+)
+
+// logFile is the per-task log file in the job directory, if any.
+var logFile *os.File
+
+// Setup replaces the default slog logger with one configured by cfg. Every
+// line carries the job id, task id, attempt and phase. Once the job is known,
+// lines are also appended to a per-task file in the job directory so that
+// they can be inspected after the pods are gone. Setup may be called again
+// when the job id becomes known.
+func Setup(cfg *config.Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", cfg.LogLevel, err)
+	}
+
+	var file *os.File
+	var w io.Writer = os.Stderr
+	if cfg.JobId != "" && cfg.TaskId != "" {
+		if err := os.MkdirAll(Dir(cfg.JobDir()), 0777); err != nil {
+			return fmt.Errorf("creating log directory: %w", err)
+		}
+		path := TaskLogPath(cfg.JobDir(), cfg.TaskId, cfg.Attempt)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file: %w", err)
+		}
+		file = f
+		w = io.MultiWriter(os.Stderr, f)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch cfg.LogFormat {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", cfg.LogFormat)
+	}
+
+	logger := slog.New(handler).With(
+		"jobId", cfg.JobId,
+		"taskId", cfg.TaskId,
+		"attempt", cfg.Attempt,
+		"phase", metrics.Phase(cfg.Mode),
+	)
+	slog.SetDefault(logger)
+
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}
+
+// Dir returns the directory inside a job directory that collects task logs.
+func Dir(jobDir string) string {
+	return filepath.Join(jobDir, "logs")
+}
+
+// TaskLogPath returns the log file of one attempt of a task.
+func TaskLogPath(jobDir, taskId string, attempt int) string {
+	return filepath.Join(Dir(jobDir), fmt.Sprintf("%s-%d.log", taskId, attempt))
+}
+
+// Fatal logs msg at error level and exits the process.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+)
+
+func TestSetupWritesTaskLog(t *testing.T) {
+	cfg := &config.Config{
+		Mode:      "mapper",
+		NfsPath:   t.TempDir(),
+		JobId:     "job-test",
+		TaskId:    "mapper-3",
+		Attempt:   1,
+		LogLevel:  "info",
+		LogFormat: "json",
+	}
+	if err := Setup(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	slog.Debug("dropped")
+	slog.Info("hello", "records", 3)
+
+	data, err := os.ReadFile(TaskLogPath(cfg.JobDir(), "mapper-3", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]any
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", data, err)
+	}
+	want := map[string]any{
+		"msg":     "hello",
+		"jobId":   "job-test",
+		"taskId":  "mapper-3",
+		"attempt": 1.0,
+		"phase":   "map",
+		"records": 3.0,
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %v", key, line[key], value)
+		}
+	}
+}
+
+func TestSetupRejectsInvalidFormat(t *testing.T) {
+	cfg := &config.Config{LogLevel: "info", LogFormat: "xml"}
+	if err := Setup(cfg); err == nil {
+		t.Error("expected an error for an invalid log format")
+	}
+}
@@ -2,141 +2,296 @@ package mapper
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"hash"
-	"hash/fnv"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/MichalPitr/map_reduce/pkg/cache"
+	"github.com/MichalPitr/map_reduce/pkg/commit"
 	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/input"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+	"github.com/MichalPitr/map_reduce/pkg/shuffle"
 )
 
-var fnvHash hash.Hash32 = fnv.New32a()
-
-// TextInput implements the MapInput interface for simple strings.
+// TextInput implements the MapInput interface for simple strings. It also
+// implements FieldsInput, and TaggedInput; the tag is empty for jobs with a
+// single input.
 type TextInput struct {
-	data string
+	ctx    context.Context
+	data   string
+	fields []string
+	tag    string
 }
 
 func (ti *TextInput) Value() string {
 	return ti.data
 }
 
+func (ti *TextInput) Context() context.Context {
+	return ti.ctx
+}
+
+func (ti *TextInput) Fields() []string {
+	return ti.fields
+}
+
+func (ti *TextInput) Tag() string {
+	return ti.tag
+}
+
 func Run(cfg *config.Config) {
-	log.Printf("Running mapper...")
-	processFiles(cfg)
+	slog.Info("Running mapper", "fileRange", cfg.FileRange, "inputTag", cfg.InputTag, "mapOnly", cfg.NumReducers == 0)
+	// Partitions, or the output file of a map-only job, are written to a
+	// temporary path and committed at once, so that retried or resumed
+	// attempts never see partial output.
+	tempPath := commit.TempPath(cfg.OutputDir, cfg.Attempt)
+	if err := os.RemoveAll(tempPath); err != nil {
+		logging.Fatal("Failed to remove output of a previous try", "path", tempPath, "err", err)
+	}
+
+	c := counters.New()
+	// Only map-only jobs write to the job output, where named outputs are
+	// committed along with the output file.
+	var namedFinal string
+	if cfg.NumReducers == 0 {
+		namedFinal = cfg.OutputDir
+	}
+	named := output.NewNamedOutputs(namedFinal, cfg.Attempt, cfg.OutputFormat, c)
+	ctx := output.NewContext(counters.NewContext(context.Background(), c), named)
+	taskCache, err := cache.Load(cfg)
+	if err != nil {
+		logging.Fatal("Failed to load the distributed cache", "err", err)
+	}
+	ctx = cache.NewContext(ctx, taskCache)
+	ctx = input.NewTagContext(ctx, cfg.InputTag)
+	processFiles(ctx, cfg, tempPath)
+	if err := named.Close(); err != nil {
+		logging.Fatal("Failed to write named outputs", "err", err)
+	}
+	c.Add(counters.MapBytesWritten, named.BytesWritten())
+	metrics.BytesWritten.WithLabelValues("map").Add(float64(named.BytesWritten()))
+
+	committed, err := commit.CommitTask(tempPath, cfg.OutputDir, named.Paths(), cfg.JobId != "")
+	if err != nil {
+		logging.Fatal("Failed to commit output", "err", err)
+	}
+	if !committed {
+		slog.Warn("Output was already committed by another attempt, discarding")
+		return
+	}
+	if err := counters.Save(c, cfg.JobId, cfg.JobDir(), cfg.TaskId); err != nil {
+		logging.Fatal("Failed to save counters", "err", err)
+	}
 }
 
-func processFiles(cfg *config.Config) {
+// processFiles maps all files in cfg.FileRange. The output is partitioned
+// into outputPath for the reducers or, when cfg.NumReducers is 0, written
+// unsorted to the file outputPath in the job's output format.
+func processFiles(ctx context.Context, cfg *config.Config, outputPath string) {
 	mapper := cfg.Mapper
 	prefix, start, end := parseFileRange(cfg.FileRange)
 
-	// Prepare output dir
-	mustCreateOutputDir(cfg.OutputDir)
+	c := counters.FromContext(ctx)
+	var emit func(key, value string)
+	var flush func()
+	if cfg.NumReducers == 0 {
+		direct := newDirectOutput(outputPath, cfg.OutputFormat, c)
+		emit = direct.emit
+		flush = direct.close
+	} else {
+		mustCreateOutputDir(outputPath)
+		intermediate := make(map[string][]string)
+		emit = func(key, value string) {
+			c.Inc(counters.MapOutputPairs)
+			intermediate[key] = append(intermediate[key], value)
+		}
+		ordering := shuffle.For(cfg)
+		flush = func() { flushData(outputPath, cfg.NumReducers, ordering, intermediate, c) }
+	}
 
-	intermediate := make(map[string][]string)
-	emit := func(key, value string) {
-		intermediate[key] = append(intermediate[key], value)
+	if s, ok := mapper.(interfaces.Setuper); ok {
+		if err := s.Setup(ctx); err != nil {
+			logging.Fatal("Mapper setup failed", "err", err)
+		}
 	}
 
+	format := cfg.InputFormat
+	if format == nil {
+		format = input.TextFormat{}
+	}
 	for i := start; i <= end; i++ {
 		fName := fmt.Sprintf("%s-%d", prefix, i)
 		filePath := filepath.Join(cfg.InputDir, fName)
-		file, err := os.Open(filePath)
-		if err != nil {
-			log.Fatalf("Failed to open file %s: %v", filePath, err)
-		}
-		defer file.Close()
+		mapFile(ctx, filePath, format, cfg.InputTag, mapper, emit)
+	}
 
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			// TODO: generalize for other input types maybe.
-			input := &TextInput{data: scanner.Text()}
-			mapper.Map(input, emit)
+	if c, ok := mapper.(interfaces.Cleaner); ok {
+		if err := c.Cleanup(ctx, emit); err != nil {
+			logging.Fatal("Mapper cleanup failed", "err", err)
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatalf("error reading from file %s: %v", filePath, err)
+	flush()
+}
+
+// mapFile maps the records of the file at path, decoded by format.
+func mapFile(ctx context.Context, path string, format interfaces.InputFormat, tag string, mapper interfaces.Mapper, emit func(key, value string)) {
+	file, err := os.Open(path)
+	if err != nil {
+		logging.Fatal("Failed to open file", "path", path, "err", err)
+	}
+	defer file.Close()
+	reader, err := format.NewRecordReader(file)
+	if err != nil {
+		logging.Fatal("Failed to read file", "path", path, "err", err)
+	}
+
+	c := counters.FromContext(ctx)
+	records := metrics.RecordsProcessed.WithLabelValues("map")
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return
 		}
+		if err != nil {
+			logging.Fatal("Error reading from file", "path", path, "err", err)
+		}
+		record := &TextInput{ctx: ctx, data: strings.Join(fields, ","), fields: fields, tag: tag}
+		c.Inc(counters.MapInputRecords)
+		records.Inc()
+		mapper.Map(record, emit)
+	}
+}
+
+// directOutput writes the pairs of a map-only job to the final output file in
+// the order they are emitted, encoded by the job's output format.
+type directOutput struct {
+	file    *os.File
+	counter *output.CountingWriter
+	writer  interfaces.RecordWriter
+	c       *counters.Counters
+}
+
+func newDirectOutput(path string, format interfaces.OutputFormat, c *counters.Counters) *directOutput {
+	mustCreateOutputDir(filepath.Dir(path))
+	file, err := os.Create(path)
+	if err != nil {
+		logging.Fatal("Failed to create output file", "path", path, "err", err)
+	}
+	if format == nil {
+		format = output.TextFormat{}
+	}
+	counter := &output.CountingWriter{W: file}
+	return &directOutput{file: file, counter: counter, writer: format.NewRecordWriter(counter), c: c}
+}
+
+func (o *directOutput) emit(key, value string) {
+	o.c.Inc(counters.MapOutputPairs)
+	if err := o.writer.Write(key, value); err != nil {
+		logging.Fatal("Failed to write to file", "path", o.file.Name(), "err", err)
 	}
+}
 
-	flushData(cfg.OutputDir, cfg.NumReducers, intermediate)
+func (o *directOutput) close() {
+	if err := o.writer.Flush(); err != nil {
+		logging.Fatal("Failed to write to file", "path", o.file.Name(), "err", err)
+	}
+	if err := o.file.Close(); err != nil {
+		logging.Fatal("Failed to close file", "path", o.file.Name(), "err", err)
+	}
+	o.c.Add(counters.MapBytesWritten, o.counter.N)
+	metrics.BytesWritten.WithLabelValues("map").Add(float64(o.counter.N))
 }
 
 func mustCreateOutputDir(dir string) {
 	if err := os.MkdirAll(dir, 0777); err != nil {
-		log.Fatalf("Creating directory %s failed: %v", dir, err)
+		logging.Fatal("Creating directory failed", "dir", dir, "err", err)
 	}
 }
 
+// parseFileRange parses prefix-start-end. The prefix may contain dashes.
 func parseFileRange(fileRange string) (string, int, int) {
 	substrings := strings.Split(fileRange, "-")
-	if len(substrings) != 3 {
-		log.Printf("Expected file range in format prefix-start-end but got %s.", fileRange)
-		os.Exit(1)
+	if len(substrings) < 3 {
+		logging.Fatal("Expected file range in format prefix-start-end", "fileRange", fileRange)
 	}
-	prefix := substrings[0]
-	start, err := strconv.Atoi(substrings[1])
+	n := len(substrings)
+	prefix := strings.Join(substrings[:n-2], "-")
+	start, err := strconv.Atoi(substrings[n-2])
 	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+		logging.Fatal("Invalid file range start", "fileRange", fileRange, "err", err)
 	}
-	end, err := strconv.Atoi(substrings[2])
+	end, err := strconv.Atoi(substrings[n-1])
 	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+		logging.Fatal("Invalid file range end", "fileRange", fileRange, "err", err)
 	}
 	return prefix, start, end
 }
 
-func flushData(outputDir string, numPartitions int, intermediate map[string][]string) {
-	// Sort keys to write in alphabetic order.
+func flushData(outputDir string, numPartitions int, ordering shuffle.Ordering, intermediate map[string][]string, c *counters.Counters) {
+	// Sort keys in the order reducers merge them.
 	keys := make([]string, 0, len(intermediate))
 	for key := range intermediate {
 		keys = append(keys, key)
 	}
-	sort.Strings(keys)
+	slices.SortFunc(keys, ordering.Compare)
 
 	// Prepare output files
+	files := make([]*os.File, 0, numPartitions)
 	writers := make([]*bufio.Writer, 0, numPartitions)
 	for p := range numPartitions {
 		partitionName := fmt.Sprintf("partition-%d", p)
 		fileName := filepath.Join(outputDir, partitionName)
 		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatalf("Failed to open file %s: %v", fileName, err)
+			logging.Fatal("Failed to open file", "path", fileName, "err", err)
 		}
-		defer file.Close()
-		writer := bufio.NewWriter(file)
-		defer writer.Flush()
-		writers = append(writers, writer)
+		files = append(files, file)
+		writers = append(writers, bufio.NewWriter(file))
 	}
 
 	// Write to files
 	for _, key := range keys {
-		p := getKeyPartition(key, numPartitions)
-		writeToFile(writers[p], key, intermediate[key])
+		p := ordering.Partition(key, numPartitions)
+		if p < 0 || p >= numPartitions {
+			logging.Fatal("Partitioner returned an invalid partition", "key", key, "partition", p)
+		}
+		n := writeToFile(writers[p], key, intermediate[key])
+		c.Add(counters.PartitionBytesWritten(p), int64(n))
+		metrics.BytesWritten.WithLabelValues("map").Add(float64(n))
 	}
-}
 
-func writeToFile(writer *bufio.Writer, key string, values []string) {
-	for _, value := range values {
-		_, err := writer.WriteString(fmt.Sprintf("%s,%s\n", key, value))
-		if err != nil {
-			log.Fatalf("Failed to write to file: %v", err)
+	// Truncated partitions must never be committed.
+	for p, writer := range writers {
+		if err := writer.Flush(); err != nil {
+			logging.Fatal("Failed to write to file", "path", files[p].Name(), "err", err)
+		}
+		if err := files[p].Close(); err != nil {
+			logging.Fatal("Failed to close file", "path", files[p].Name(), "err", err)
 		}
 	}
 }
 
-func getKeyPartition(key string, numPartitions int) int {
-	hash, err := fnvHash.Write([]byte(key))
-	if err != nil {
-		log.Fatalf("Error calculating hash: %v", err)
+// writeToFile writes all values of a key and returns the number of bytes written.
+func writeToFile(writer *bufio.Writer, key string, values []string) int {
+	written := 0
+	for _, value := range values {
+		n, err := writer.WriteString(fmt.Sprintf("%s,%s\n", key, value))
+		if err != nil {
+			logging.Fatal("Failed to write to file", "err", err)
+		}
+		written += n
 	}
-	defer fnvHash.Reset()
-	return hash % numPartitions
+	return written
 }
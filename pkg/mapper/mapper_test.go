@@ -1,12 +1,18 @@
 package mapper
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/input"
 	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/output"
 )
 
 func BenchmarkMapper(b *testing.B) {
@@ -38,3 +44,270 @@ func NewTestConfig() *config.Config {
 	cfg := config.Config{}
 	return &cfg
 }
+
+// countingMapper counts words in memory and emits the totals on cleanup.
+type countingMapper struct {
+	setupCalled bool
+	counts      map[string]int
+}
+
+func (cm *countingMapper) Setup(ctx context.Context) error {
+	cm.setupCalled = true
+	cm.counts = make(map[string]int)
+	return nil
+}
+
+func (cm *countingMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	for _, word := range strings.Fields(input.Value()) {
+		cm.counts[word]++
+	}
+}
+
+func (cm *countingMapper) Cleanup(ctx context.Context, emit func(key, value string)) error {
+	for word, count := range cm.counts {
+		emit(word, strconv.Itoa(count))
+	}
+	return nil
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "book-0"), []byte("a b a\nb a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := &countingMapper{}
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = filepath.Join(t.TempDir(), "mapper-0")
+	cfg.FileRange = "book-0-0"
+	cfg.NumReducers = 1
+	cfg.Mapper = mapper
+	Run(cfg)
+
+	if !mapper.setupCalled {
+		t.Error("Setup was not called")
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "partition-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a,3\nb,2\n"
+	if string(got) != want {
+		t.Errorf("partition-0 = %q, want %q", got, want)
+	}
+}
+
+func TestOutputDirWithTrailingSlash(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "book-0"), []byte("a b a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = filepath.Join(t.TempDir(), "mapper-0") + "/"
+	cfg.FileRange = "book-0-0"
+	cfg.NumReducers = 1
+	cfg.Mapper = &countingMapper{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "partition-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a,2\nb,1\n" {
+		t.Errorf("partition-0 = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "_temporary")); !os.IsNotExist(err) {
+		t.Error("the attempt wrote inside the output directory")
+	}
+}
+
+// upperMapper keeps lines containing "a" and upper-cases them.
+type upperMapper struct{}
+
+func (um *upperMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	if strings.Contains(input.Value(), "a") {
+		emit(strings.ToUpper(input.Value()), "")
+	}
+}
+
+func TestMapOnly(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "book-0"), []byte("c a\nb\na c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = filepath.Join(t.TempDir(), "output", "mapper-0")
+	cfg.FileRange = "book-0-0"
+	cfg.NumReducers = 0
+	cfg.Mapper = &upperMapper{}
+	Run(cfg)
+
+	// Output is neither sorted nor partitioned.
+	got, err := os.ReadFile(cfg.OutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "C A,\nA C,\n"
+	if string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+type tagMapper struct{}
+
+func (tm *tagMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	emit(input.(interfaces.TaggedInput).Tag(), input.Value())
+}
+
+func TestInputTag(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "users-0"), []byte("ann\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.InputTag = "users"
+	cfg.OutputDir = filepath.Join(t.TempDir(), "mapper-0")
+	cfg.FileRange = "users-0-0"
+	cfg.NumReducers = 0
+	cfg.Mapper = &tagMapper{}
+	Run(cfg)
+
+	got, err := os.ReadFile(cfg.OutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "users,ann\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+type columnsMapper struct{}
+
+func (cm *columnsMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	fields := input.(interfaces.FieldsInput).Fields()
+	emit(fields[0], strconv.Itoa(len(fields)))
+}
+
+func TestInputFormat(t *testing.T) {
+	inputDir := t.TempDir()
+	data := "id,name,city\n1,\"Smith, Ann\",Prague\n2,Bob\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "users-0"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.InputFormat = input.CSVFormat{}
+	cfg.OutputDir = filepath.Join(t.TempDir(), "mapper-0")
+	cfg.FileRange = "users-0-0"
+	cfg.NumReducers = 0
+	cfg.Mapper = &columnsMapper{}
+	Run(cfg)
+
+	got, err := os.ReadFile(cfg.OutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,3\n1,3\n2,2\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+type splitMapper struct{}
+
+func (sm *splitMapper) Map(input interfaces.MapInput, emit func(key, value string)) {
+	if strings.HasPrefix(input.Value(), "#") {
+		output.Named(input.Context(), "comments")(input.Value(), "")
+		return
+	}
+	emit(input.Value(), "1")
+}
+
+func TestMapOnlyNamedOutputs(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "book-0"), []byte("# title\nword\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "output")
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = filepath.Join(outputDir, "mapper-0")
+	cfg.FileRange = "book-0-0"
+	cfg.NumReducers = 0
+	cfg.Mapper = &splitMapper{}
+	Run(cfg)
+
+	for path, want := range map[string]string{
+		"mapper-0":          "word,1\n",
+		"comments/mapper-0": "# title,\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(outputDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseFileRange(t *testing.T) {
+	for _, tc := range []struct {
+		fileRange  string
+		prefix     string
+		start, end int
+	}{
+		{"book-0-80", "book", 0, 80},
+		{"reducer-2-3", "reducer", 2, 3},
+		{"part-r-0-1", "part-r", 0, 1},
+	} {
+		prefix, start, end := parseFileRange(tc.fileRange)
+		if prefix != tc.prefix || start != tc.start || end != tc.end {
+			t.Errorf("parseFileRange(%q) = %q, %d, %d", tc.fileRange, prefix, start, end)
+		}
+	}
+}
+
+// descending sorts keys in reverse and sends all keys to the last partition.
+type descending struct{}
+
+func (descending) Reduce(input interfaces.ReducerInput, emit func(string)) {}
+
+func (descending) CompareKeys(a, b string) int { return strings.Compare(b, a) }
+
+func (descending) Partition(key string, numPartitions int) int { return numPartitions - 1 }
+
+func TestFlushUsesReducerOrdering(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "book-0"), []byte("a b c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = filepath.Join(t.TempDir(), "mapper-0")
+	cfg.FileRange = "book-0-0"
+	cfg.NumReducers = 2
+	cfg.Mapper = &countingMapper{}
+	cfg.Reducer = descending{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "partition-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c,1\nb,1\na,1\n"; string(got) != want {
+		t.Errorf("partition-1 = %q, want %q", got, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(cfg.OutputDir, "partition-0")); len(got) != 0 {
+		t.Errorf("partition-0 = %q, want it empty", got)
+	}
+}
@@ -2,15 +2,37 @@ package mapreduce
 
 import (
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
 	"github.com/MichalPitr/map_reduce/pkg/mapper"
 	"github.com/MichalPitr/map_reduce/pkg/master"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
 	"github.com/MichalPitr/map_reduce/pkg/reducer"
 )
 
 func Execute(cfg *config.Config) {
+	if err := logging.Setup(cfg); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// The master serves metrics along with its job status page. Deploy only
+	// forwards the address to the master.
+	if cfg.HttpAddr != "" && cfg.Mode != "master" && cfg.Mode != "deploy" {
+		addr, err := metrics.Serve(cfg.HttpAddr, http.NewServeMux())
+		if err != nil {
+			logging.Fatal("Failed to start HTTP server", "err", err)
+		}
+		slog.Info("Serving metrics", "addr", addr)
+	}
+
+	if err := cfg.SelectFunctions(); err != nil {
+		logging.Fatal("Invalid job functions", "err", err)
+	}
+
 	switch cfg.Mode {
 	case "master":
 		master.Run(cfg)
@@ -18,8 +40,16 @@ func Execute(cfg *config.Config) {
 		mapper.Run(cfg)
 	case "reducer":
 		reducer.Run(cfg)
+	case "cleanup":
+		master.Cleanup(cfg)
+	case "deploy":
+		master.Deploy(cfg)
+	case "controller":
+		master.RunController(cfg)
+	case "pipeline":
+		master.RunPipeline(cfg)
 	default:
-		log.Printf("Invalid mode specified: %q", cfg.Mode)
+		slog.Error("Invalid mode specified", "mode", cfg.Mode)
 		os.Exit(128)
 	}
 }
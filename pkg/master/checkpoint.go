@@ -0,0 +1,176 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+	"github.com/MichalPitr/map_reduce/pkg/config"
+)
+
+// checkpointFile is the file in the job directory the job state is persisted to.
+const checkpointFile = "state.json"
+
+// taskCheckpoint is the persisted state of a single task.
+type taskCheckpoint struct {
+	// Attempts is the number of times the task has been launched. It is also
+	// the attempt number of the next launch.
+	Attempts  int    `json:"attempts"`
+	Committed bool   `json:"committed"`
+	Output    string `json:"output"`
+}
+
+// jobCheckpoint is the job plan and progress persisted in the job directory,
+// which allows resuming a job after the master was interrupted. InputTags
+// holds the tag of the input each mapper reads, in the order of FileRanges;
+// it is empty for jobs reading InputDir.
+type jobCheckpoint struct {
+	JobId        string                     `json:"jobId"`
+	InputDir     string                     `json:"inputDir"`
+	Image        string                     `json:"image"`
+	NumMappers   int                        `json:"numMappers"`
+	NumReducers  int                        `json:"numReducers"`
+	Mapper       string                     `json:"mapper,omitempty"`
+	Reducer      string                     `json:"reducer,omitempty"`
+	OutputFormat string                     `json:"outputFormat,omitempty"`
+	InputFormat  string                     `json:"inputFormat,omitempty"`
+	Inputs       []config.Input             `json:"inputs,omitempty"`
+	CacheFiles   map[string]string          `json:"cacheFiles,omitempty"`
+	FileRanges   []string                   `json:"fileRanges"`
+	InputTags    []string                   `json:"inputTags,omitempty"`
+	Phase        string                     `json:"phase"`
+	Tasks        map[string]*taskCheckpoint `json:"tasks"`
+}
+
+func newCheckpoint(cfg *config.Config, jobId string, fileRanges, inputTags []string) *jobCheckpoint {
+	cp := &jobCheckpoint{
+		JobId:        jobId,
+		InputDir:     cfg.InputDir,
+		Image:        cfg.Image,
+		NumMappers:   cfg.NumMappers,
+		NumReducers:  cfg.NumReducers,
+		Mapper:       cfg.MapperName,
+		Reducer:      cfg.ReducerName,
+		OutputFormat: cfg.OutputFormatName,
+		InputFormat:  cfg.InputFormatName,
+		Inputs:       cfg.Inputs,
+		CacheFiles:   cfg.CacheFiles,
+		FileRanges:   fileRanges,
+		InputTags:    inputTags,
+		Phase:        "pending",
+		Tasks:        make(map[string]*taskCheckpoint),
+	}
+	for i := range fileRanges {
+		taskId := fmt.Sprintf("mapper-%d", i)
+		cp.Tasks[taskId] = &taskCheckpoint{Output: mapperOutput(cfg, jobId, taskId)}
+	}
+	for i := 0; i < cfg.NumReducers; i++ {
+		taskId := fmt.Sprintf("reducer-%d", i)
+		cp.Tasks[taskId] = &taskCheckpoint{Output: filepath.Join(outputDir(cfg.NfsPath, jobId), taskId)}
+	}
+	return cp
+}
+
+func loadCheckpoint(jobDir string) (*jobCheckpoint, error) {
+	data, err := os.ReadFile(filepath.Join(jobDir, checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+	cp := &jobCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", checkpointFile, err)
+	}
+	return cp, nil
+}
+
+// save atomically replaces the checkpoint in the job directory.
+func (cp *jobCheckpoint) save(jobDir string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(jobDir, checkpointFile+".tmp")
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(jobDir, checkpointFile))
+}
+
+// applyTo restores the job plan into cfg. An image passed on the command line
+// takes precedence, which allows resuming with a fixed image.
+func (cp *jobCheckpoint) applyTo(cfg *config.Config) {
+	cfg.InputDir = cp.InputDir
+	cfg.NumMappers = cp.NumMappers
+	cfg.NumReducers = cp.NumReducers
+	cfg.MapperName = cp.Mapper
+	cfg.ReducerName = cp.Reducer
+	if cp.OutputFormat != "" {
+		cfg.OutputFormatName = cp.OutputFormat
+	}
+	if cp.InputFormat != "" {
+		cfg.InputFormatName = cp.InputFormat
+	}
+	cfg.Inputs = cp.Inputs
+	cfg.CacheFiles = cp.CacheFiles
+	if cfg.Image == "" {
+		cfg.Image = cp.Image
+	}
+}
+
+// mapperInput returns the input mapper i reads. Jobs without tagged inputs
+// read InputDir with the mapper and in the input format of the job.
+func (cp *jobCheckpoint) mapperInput(cfg *config.Config, i int) config.Input {
+	input := config.Input{Dir: cfg.InputDir}
+	if i < len(cp.InputTags) {
+		for _, candidate := range cp.Inputs {
+			if candidate.Tag == cp.InputTags[i] {
+				input = candidate
+				break
+			}
+		}
+	}
+	if input.Mapper == "" {
+		input.Mapper = cfg.MapperName
+	}
+	if input.Format == "" {
+		input.Format = cfg.InputFormatName
+	}
+	return input
+}
+
+// nextAttempt records a launch of the task and returns its attempt number.
+func (cp *jobCheckpoint) nextAttempt(taskId string) int {
+	task := cp.Tasks[taskId]
+	attempt := task.Attempts
+	task.Attempts++
+	return attempt
+}
+
+func (cp *jobCheckpoint) committed(taskId string) bool {
+	return cp.Tasks[taskId].Committed
+}
+
+// refresh marks tasks whose output has been committed, including tasks that
+// finished while no master was watching.
+func (cp *jobCheckpoint) refresh() {
+	for _, task := range cp.Tasks {
+		if !task.Committed && commit.Committed(task.Output) {
+			task.Committed = true
+		}
+	}
+}
+
+// uncommitted returns the ids of the tasks with the given prefix that have
+// not committed their output.
+func (cp *jobCheckpoint) uncommitted(prefix string, numTasks int) []string {
+	missing := make([]string, 0)
+	for i := 0; i < numTasks; i++ {
+		taskId := fmt.Sprintf("%s-%d", prefix, i)
+		if !cp.Tasks[taskId].Committed {
+			missing = append(missing, taskId)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,61 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+	"github.com/MichalPitr/map_reduce/pkg/config"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	cfg := &config.Config{NfsPath: t.TempDir(), InputDir: "/mnt/nfs/input", Image: "image:v1", NumMappers: 2, NumReducers: 1}
+	jobId := "job-test"
+	jobDir := filepath.Join(cfg.NfsPath, jobId)
+	if err := os.Mkdir(jobDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	cp := newCheckpoint(cfg, jobId, []string{"book-0-4", "book-5-9"}, nil)
+	if attempt := cp.nextAttempt("mapper-0"); attempt != 0 {
+		t.Errorf("first attempt = %d, want 0", attempt)
+	}
+	cp.nextAttempt("mapper-1")
+	if err := cp.save(jobDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only mapper-1 committed before the master was interrupted. mapper-0
+	// moved its output into place but failed before writing its marker.
+	for _, taskId := range []string{"mapper-0", "mapper-1"} {
+		if err := os.MkdirAll(commit.TempPath(filepath.Join(jobDir, taskId), 0), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(commit.TempPath(filepath.Join(jobDir, "mapper-0"), 0), filepath.Join(jobDir, "mapper-0")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit.Commit(commit.TempPath(filepath.Join(jobDir, "mapper-1"), 0), filepath.Join(jobDir, "mapper-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCheckpoint(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.refresh()
+	if got := loaded.uncommitted("mapper", 2); !slices.Equal(got, []string{"mapper-0"}) {
+		t.Errorf("uncommitted mappers = %v, want [mapper-0]", got)
+	}
+	if attempt := loaded.nextAttempt("mapper-0"); attempt != 1 {
+		t.Errorf("resumed attempt = %d, want 1", attempt)
+	}
+
+	resumed := &config.Config{}
+	loaded.applyTo(resumed)
+	if resumed.InputDir != cfg.InputDir || resumed.NumMappers != 2 || resumed.NumReducers != 1 || resumed.Image != "image:v1" {
+		t.Errorf("plan not restored: %+v", resumed)
+	}
+}
@@ -0,0 +1,106 @@
+package master
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/cache"
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// jobIdLabel is set on all Kubernetes Jobs and pods of a MapReduce job.
+const jobIdLabel = "mapreduce/job-id"
+
+// cleanupTimeout bounds cleanup that runs after the job context was cancelled.
+const cleanupTimeout = 30 * time.Second
+
+// Cleanup deletes the Kubernetes Jobs, pods and intermediate data of an
+// abandoned job. Task logs, counters and reducer output are kept.
+func Cleanup(cfg *config.Config) {
+	if cfg.JobId == "" {
+		logging.Fatal("Must provide --job-id to clean up.")
+	}
+	clientset, err := createKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if err := deleteKubernetesJobs(ctx, clientset, cfg.Namespace, cfg.JobId); err != nil {
+		logging.Fatal("Failed to delete Kubernetes jobs", "err", err)
+	}
+	if err := deleteIntermediateData(cfg.NfsPath, cfg.JobId); err != nil {
+		logging.Fatal("Failed to delete intermediate data", "err", err)
+	}
+	slog.Info("Cleaned up job")
+}
+
+// deleteKubernetesJobs deletes all Kubernetes Jobs of a MapReduce job along
+// with the ConfigMap owning them. Their pods are garbage collected by Kubernetes.
+func deleteKubernetesJobs(ctx context.Context, clientset kubernetes.Interface, namespace, jobId string) error {
+	policy := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &policy}
+	err := clientset.BatchV1().Jobs(namespace).DeleteCollection(ctx, options,
+		metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", jobIdLabel, jobId)},
+	)
+	if err != nil {
+		return err
+	}
+	err = clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, jobId, options)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	slog.Info("Deleted Kubernetes jobs")
+	return nil
+}
+
+// deleteIntermediateData removes the mapper output directories of a job and
+// their commit markers, the output of uncommitted attempts, including those
+// of reducers in the job output, and the staged cache files.
+func deleteIntermediateData(nfsPath, jobId string) error {
+	jobDir := filepath.Join(nfsPath, jobId)
+	entries, err := os.ReadDir(jobDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasPrefix(name, "mapper-") && name != commit.TempDirName && name != commit.MarkerDirName && name != cache.DirName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(jobDir, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(outputDir(nfsPath, jobId), commit.TempDirName)); err != nil {
+		return err
+	}
+	slog.Info("Deleted intermediate data", "jobDir", jobDir)
+	return nil
+}
+
+// abortJob deletes the Kubernetes Jobs of a job that failed or was cancelled
+// and returns the error that caused it.
+func abortJob(clientset kubernetes.Interface, namespace, jobId string, err error) error {
+	slog.Error("Aborting job", "err", err)
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := deleteKubernetesJobs(ctx, clientset, namespace, jobId); err != nil {
+		slog.Error("Failed to delete Kubernetes jobs", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/cache"
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+)
+
+func TestDeleteIntermediateData(t *testing.T) {
+	nfsPath := t.TempDir()
+	jobDir := filepath.Join(nfsPath, "job-1")
+	output := outputDir(nfsPath, "job-1")
+	deleted := []string{
+		filepath.Join(jobDir, "mapper-0", "partition-0"),
+		filepath.Join(jobDir, commit.TempDirName, "mapper-1-0", "partition-0"),
+		filepath.Join(jobDir, commit.MarkerDirName, "mapper-0"),
+		filepath.Join(jobDir, cache.DirName, "stopwords"),
+		filepath.Join(output, commit.TempDirName, "reducer-1-0"),
+	}
+	kept := []string{
+		filepath.Join(jobDir, checkpointFile),
+		filepath.Join(jobDir, "logs", "mapper-0-0.log"),
+		filepath.Join(jobDir, "counters", "reducer-0.json"),
+		filepath.Join(output, "reducer-0"),
+		filepath.Join(output, commit.MarkerDirName, "reducer-0"),
+	}
+	for _, path := range append(deleted, kept...) {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := deleteIntermediateData(nfsPath, "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range deleted {
+		if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted", filepath.Dir(path))
+		}
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was deleted: %v", path, err)
+		}
+	}
+}
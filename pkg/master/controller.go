@@ -0,0 +1,346 @@
+package master
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// mapReduceJobResource is the MapReduceJob custom resource reconciled in
+// controller mode. Its definition is in deploy/mapreducejob-crd.yaml.
+var mapReduceJobResource = schema.GroupVersionResource{
+	Group:    "mapreduce.michalpitr.github.io",
+	Version:  "v1alpha1",
+	Resource: "mapreducejobs",
+}
+
+// Phases of a MapReduceJob.
+const (
+	jobPhasePending   = "Pending"
+	jobPhaseMap       = "Map"
+	jobPhaseReduce    = "Reduce"
+	jobPhaseSucceeded = "Succeeded"
+	jobPhaseFailed    = "Failed"
+)
+
+type mapReduceJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              mapReduceJobSpec   `json:"spec"`
+	Status            mapReduceJobStatus `json:"status,omitempty"`
+}
+
+type mapReduceJobSpec struct {
+	Image       string `json:"image"`
+	InputDir    string `json:"inputDir"`
+	NumMappers  int    `json:"numMappers"`
+	NumReducers int    `json:"numReducers"`
+	// Mapper and Reducer name registered functions. They default to the
+	// functions set in code.
+	Mapper  string `json:"mapper,omitempty"`
+	Reducer string `json:"reducer,omitempty"`
+	// OutputFormat and InputFormat default to the formats of the
+	// controller.
+	OutputFormat string `json:"outputFormat,omitempty"`
+	InputFormat  string `json:"inputFormat,omitempty"`
+}
+
+type mapReduceJobStatus struct {
+	Phase string `json:"phase,omitempty"`
+	// JobId is set once the job has started. A controller restarted while
+	// the job runs resumes it.
+	JobId     string `json:"jobId,omitempty"`
+	OutputDir string `json:"outputDir,omitempty"`
+	// Tasks is the number of tasks in each state, e.g. running or succeeded.
+	Tasks          map[string]int `json:"tasks,omitempty"`
+	Message        string         `json:"message,omitempty"`
+	StartTime      *metav1.Time   `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time   `json:"completionTime,omitempty"`
+}
+
+func mapReduceJobFromUnstructured(obj *unstructured.Unstructured) (*mapReduceJob, error) {
+	job := &mapReduceJob{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// RunController runs the MapReduceJobs created in the cluster, one at a time,
+// until it is interrupted. Settings that are not part of a MapReduceJob, e.g.
+// the NFS path, are taken from cfg.
+func RunController(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	restCfg, err := restConfig(cfg.Kubeconfig)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	client, err := dynamic.NewForConfig(restCfg)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	if err := runController(ctx, cfg, clientset, client); err != nil {
+		logging.Fatal("Controller failed", "err", err)
+	}
+}
+
+// controller reconciles MapReduceJobs by running the master for each of them
+// and reporting its progress in their status.
+type controller struct {
+	cfg       *config.Config
+	clientset kubernetes.Interface
+	client    dynamic.ResourceInterface
+	queue     workqueue.Interface
+
+	mu      sync.Mutex
+	running string
+	cancel  context.CancelFunc
+}
+
+func runController(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, client dynamic.Interface) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, cfg.Namespace, nil)
+	informer := factory.ForResource(mapReduceJobResource)
+	c := &controller{
+		cfg:       cfg,
+		clientset: clientset,
+		client:    client.Resource(mapReduceJobResource).Namespace(cfg.Namespace),
+		queue:     workqueue.New(),
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj any) { c.enqueue(obj) },
+		DeleteFunc: c.cancelDeleted,
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		return ctx.Err()
+	}
+	slog.Info("Watching MapReduceJobs")
+
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+	for c.processNext(ctx) {
+	}
+	return nil
+}
+
+func (c *controller) enqueue(obj any) {
+	if obj, ok := obj.(*unstructured.Unstructured); ok {
+		c.queue.Add(obj.GetName())
+	}
+}
+
+// cancelDeleted aborts the running job when its MapReduceJob is deleted.
+func (c *controller) cancelDeleted(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running == job.GetName() {
+		slog.Info("MapReduceJob deleted, aborting it", "name", job.GetName())
+		c.cancel()
+	}
+}
+
+func (c *controller) processNext(ctx context.Context) bool {
+	name, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(name)
+	c.reconcile(ctx, name.(string))
+	return true
+}
+
+// reconcile runs a MapReduceJob that has not finished yet. Jobs that were
+// started by a previous controller are resumed.
+func (c *controller) reconcile(ctx context.Context, name string) {
+	// The informer cache may not have seen the final status of a job that
+	// just finished, so the MapReduceJob is read from the API server.
+	obj, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return
+	} else if err != nil {
+		slog.Error("Failed to get MapReduceJob", "name", name, "err", err)
+		return
+	}
+	job, err := mapReduceJobFromUnstructured(obj)
+	if err != nil {
+		slog.Error("Invalid MapReduceJob", "name", name, "err", err)
+		return
+	}
+	if job.Status.Phase == jobPhaseSucceeded || job.Status.Phase == jobPhaseFailed {
+		return
+	}
+
+	cfg := *c.cfg
+	cfg.Image = job.Spec.Image
+	cfg.InputDir = job.Spec.InputDir
+	cfg.NumMappers = job.Spec.NumMappers
+	cfg.NumReducers = job.Spec.NumReducers
+	cfg.MapperName = job.Spec.Mapper
+	cfg.ReducerName = job.Spec.Reducer
+	if job.Spec.OutputFormat != "" {
+		cfg.OutputFormatName = job.Spec.OutputFormat
+	}
+	if job.Spec.InputFormat != "" {
+		cfg.InputFormatName = job.Spec.InputFormat
+	}
+	cfg.Resume = job.Status.JobId
+	// The controller only serves metrics, on the address it was started
+	// with. Jobs must not start a status page on the same address.
+	cfg.HttpAddr = ""
+	slog.Info("Running MapReduceJob", "name", name, "resume", cfg.Resume)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.mu.Lock()
+	c.running, c.cancel = name, cancel
+	c.mu.Unlock()
+
+	// Times are stored with second precision, so they are truncated to
+	// compare statuses with what was written.
+	startTime := job.Status.StartTime
+	if startTime == nil {
+		now := metav1.Now().Rfc3339Copy()
+		startTime = &now
+	}
+	status := newJobStatus("")
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for {
+			select {
+			case <-done:
+				return
+			case <-status.changed:
+				c.updateStatus(ctx, name, func(s *mapReduceJobStatus) {
+					reportProgress(s, cfg.NfsPath, status)
+					s.StartTime = startTime
+				})
+			}
+		}
+	}()
+
+	err = run(jobCtx, &cfg, c.clientset, status)
+	close(done)
+	<-reported
+	c.mu.Lock()
+	c.running, c.cancel = "", nil
+	c.mu.Unlock()
+	// Jobs log to their own directory; switch back to the controller log.
+	if err := logging.Setup(c.cfg); err != nil {
+		slog.Warn("Failed to reset logging", "err", err)
+	}
+
+	if ctx.Err() != nil {
+		// The controller is shutting down. The job is resumed on restart.
+		return
+	}
+	c.updateStatus(ctx, name, func(s *mapReduceJobStatus) {
+		reportProgress(s, cfg.NfsPath, status)
+		s.StartTime = startTime
+		completionTime := metav1.Now().Rfc3339Copy()
+		s.CompletionTime = &completionTime
+		if err != nil {
+			s.Phase = jobPhaseFailed
+			s.Message = err.Error()
+		} else {
+			s.Phase = jobPhaseSucceeded
+			s.Message = ""
+		}
+	})
+	slog.Info("MapReduceJob finished", "name", name, "err", err)
+}
+
+// reportProgress copies the live state of a job into the status of its
+// MapReduceJob.
+func reportProgress(s *mapReduceJobStatus, nfsPath string, status *jobStatus) {
+	jobId, phase, tasks := status.summary()
+	if jobId != "" {
+		s.JobId = jobId
+		s.OutputDir = outputDir(nfsPath, jobId)
+	}
+	switch phase {
+	case "map":
+		s.Phase = jobPhaseMap
+	case "reduce", "done":
+		// Succeeded is only reported once the master has cleaned up.
+		s.Phase = jobPhaseReduce
+	default:
+		s.Phase = jobPhasePending
+	}
+	if len(tasks) > 0 {
+		s.Tasks = tasks
+	}
+}
+
+// updateStatus applies update to the status of a MapReduceJob, retrying on
+// conflicts. Unchanged statuses are not written.
+func (c *controller) updateStatus(ctx context.Context, name string, update func(*mapReduceJobStatus)) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := c.client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		job, err := mapReduceJobFromUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		status := job.Status
+		status.Tasks = maps.Clone(job.Status.Tasks)
+		update(&status)
+		if equality.Semantic.DeepEqual(status, job.Status) {
+			return nil
+		}
+		obj.Object["status"], err = runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			return err
+		}
+		_, err = c.client.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return
+	} else if err != nil {
+		slog.Warn("Failed to update MapReduceJob status", "name", name, "err", err)
+	}
+}
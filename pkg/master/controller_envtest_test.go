@@ -0,0 +1,167 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// startAPIServer starts etcd and kube-apiserver with the MapReduceJob
+// resource installed. The test is skipped when the envtest binaries are not
+// available, see setup-envtest.
+func startAPIServer(t *testing.T) *rest.Config {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{"../../deploy/mapreducejob-crd.yaml"},
+		ErrorIfCRDPathMissing: true,
+	}
+	restCfg, err := env.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stopping the API server: %v", err)
+		}
+	})
+	return restCfg
+}
+
+// applyManifests creates every object of a multi-document YAML file.
+func applyManifests(t *testing.T, restCfg *rest.Config, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dynamic.NewForConfig(restCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var obj unstructured.Unstructured
+		if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resource dynamic.ResourceInterface = client.Resource(mapping.Resource)
+		if obj.GetNamespace() != "" {
+			resource = client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+		if _, err := resource.Create(context.Background(), &obj, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("creating %s %s: %v", gvk.Kind, obj.GetName(), err)
+		}
+	}
+}
+
+// TestControllerWithAPIServer runs the controller as the service account of
+// deploy/mapreduce-controller.yaml against a real API server. There is no
+// kubelet, so tasks never start; the job must reach the map phase.
+func TestControllerWithAPIServer(t *testing.T) {
+	restCfg := startAPIServer(t)
+	applyManifests(t, restCfg, "../../deploy/mapreduce-controller.yaml")
+	admin, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminClient, err := dynamic.NewForConfig(restCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// Every task must be able to run on its own node.
+	for _, name := range []string{"node-0", "node-1"} {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if _, err := admin.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controllerCfg := rest.CopyConfig(restCfg)
+	controllerCfg.Impersonate = rest.ImpersonationConfig{UserName: "system:serviceaccount:default:mapreduce-controller"}
+	clientset, err := kubernetes.NewForConfig(controllerCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dynamic.NewForConfig(controllerCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newRunTestConfig(t)
+	controllerCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- runController(controllerCtx, cfg, clientset, client)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("controller failed: %v", err)
+		}
+	})
+
+	jobs := adminClient.Resource(mapReduceJobResource).Namespace("default")
+	if _, err := jobs.Create(ctx, newMapReduceJob("word-count", cfg.InputDir, 2), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		obj, err := jobs.Get(ctx, "word-count", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := mapReduceJobFromUnstructured(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Phase == jobPhaseMap {
+			break
+		}
+		if job.Status.Phase == jobPhaseFailed {
+			t.Fatalf("job failed: %s", job.Status.Message)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not reach the map phase: %+v", job.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	tasks, err := admin.BatchV1().Jobs("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks.Items) != 2 {
+		t.Errorf("launched %d Kubernetes Jobs, want 2 mappers", len(tasks.Items))
+	}
+}
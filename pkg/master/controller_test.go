@@ -0,0 +1,198 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func newMapReduceJob(name, inputDir string, numMappers int) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": mapReduceJobResource.GroupVersion().String(),
+		"kind":       "MapReduceJob",
+		"metadata":   map[string]any{"name": name, "namespace": "default"},
+		"spec": map[string]any{
+			"image":       "mapreduce:test",
+			"inputDir":    inputDir,
+			"numMappers":  int64(numMappers),
+			"numReducers": int64(2),
+		},
+	}}
+}
+
+// startController runs the controller against cluster until the test ends.
+func startController(t *testing.T, cfg *config.Config, cluster *fakeCluster, objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{mapReduceJobResource: "MapReduceJobList"}, objects...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runController(ctx, cfg, cluster.client, client)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("controller failed: %v", err)
+		}
+	})
+	return client
+}
+
+// waitForFinalPhase waits until a MapReduceJob has reached a final phase.
+func waitForFinalPhase(t *testing.T, client *dynamicfake.FakeDynamicClient, name string) *mapReduceJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		obj, err := client.Resource(mapReduceJobResource).Namespace("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := mapReduceJobFromUnstructured(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Phase == jobPhaseSucceeded || job.Status.Phase == jobPhaseFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s did not finish", name)
+	return nil
+}
+
+func TestControllerRunsJobs(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2)
+	client := startController(t, cfg, cluster,
+		newMapReduceJob("first", cfg.InputDir, 2),
+		newMapReduceJob("second", cfg.InputDir, 1),
+	)
+
+	for _, name := range []string{"first", "second"} {
+		job := waitForFinalPhase(t, client, name)
+		if job.Status.Phase != jobPhaseSucceeded {
+			t.Fatalf("%s: phase = %q, message = %q", name, job.Status.Phase, job.Status.Message)
+		}
+		if job.Status.OutputDir != filepath.Join(cfg.NfsPath, job.Status.JobId, "output") {
+			t.Errorf("%s: output dir = %q", name, job.Status.OutputDir)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.NfsPath, job.Status.JobId, "summary.json")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		want := job.Spec.NumMappers + job.Spec.NumReducers
+		if job.Status.Tasks["succeeded"] != want {
+			t.Errorf("%s: tasks = %v, want %d succeeded", name, job.Status.Tasks, want)
+		}
+		if job.Status.StartTime == nil || job.Status.CompletionTime == nil {
+			t.Errorf("%s: missing start or completion time", name)
+		}
+	}
+}
+
+func TestControllerReportsFailure(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2)
+	client := startController(t, cfg, cluster, newMapReduceJob("too-many-mappers", cfg.InputDir, 3))
+
+	job := waitForFinalPhase(t, client, "too-many-mappers")
+	if job.Status.Phase != jobPhaseFailed || !strings.Contains(job.Status.Message, "more mappers or reducers than available nodes") {
+		t.Errorf("unexpected status %+v", job.Status)
+	}
+	if len(cluster.launchedTasks()) != 0 {
+		t.Errorf("launched %v", cluster.launchedTasks())
+	}
+}
+
+func TestCustomResourceDefinition(t *testing.T) {
+	data, err := os.ReadFile("../../deploy/mapreducejob-crd.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var crd struct {
+		Spec struct {
+			Group string `json:"group"`
+			Names struct {
+				Plural string `json:"plural"`
+			} `json:"names"`
+			Versions []struct {
+				Name string `json:"name"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		t.Fatal(err)
+	}
+	if crd.Spec.Group != mapReduceJobResource.Group || crd.Spec.Names.Plural != mapReduceJobResource.Resource ||
+		len(crd.Spec.Versions) != 1 || crd.Spec.Versions[0].Name != mapReduceJobResource.Version {
+		t.Errorf("CRD %+v does not define %v", crd.Spec, mapReduceJobResource)
+	}
+}
+
+// allows reports whether rules grant verb on resource in group.
+func allows(rules []rbacv1.PolicyRule, group, resource, verb string) bool {
+	return slices.ContainsFunc(rules, func(rule rbacv1.PolicyRule) bool {
+		return slices.Contains(rule.APIGroups, group) && slices.Contains(rule.Resources, resource) && slices.Contains(rule.Verbs, verb)
+	})
+}
+
+func TestControllerManifests(t *testing.T) {
+	data, err := os.ReadFile("../../deploy/mapreduce-controller.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var role, nodesRole rbacv1.Role
+	for _, doc := range bytes.Split(data, []byte("\n---\n")) {
+		var kind struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal(doc, &kind); err != nil {
+			t.Fatal(err)
+		}
+		switch kind.Kind {
+		case "Role":
+			err = yaml.Unmarshal(doc, &role)
+		case "ClusterRole":
+			err = yaml.Unmarshal(doc, &nodesRole)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group := mapReduceJobResource.Group
+	for _, verb := range []string{"get", "list", "watch"} {
+		if !allows(role.Rules, group, mapReduceJobResource.Resource, verb) {
+			t.Errorf("role does not allow %s on MapReduceJobs", verb)
+		}
+	}
+	if !allows(role.Rules, group, mapReduceJobResource.Resource+"/status", "update") {
+		t.Error("role does not allow updating the status of MapReduceJobs")
+	}
+	// The controller runs the master, so it needs every rule of the master.
+	masterRole := deployManifests(newRunTestConfig(t), time.Now())[1].(*rbacv1.Role)
+	for _, rule := range masterRole.Rules {
+		for _, verb := range rule.Verbs {
+			if !allows(role.Rules, rule.APIGroups[0], rule.Resources[0], verb) {
+				t.Errorf("role does not allow %s on %s", verb, rule.Resources[0])
+			}
+		}
+	}
+	if !allows(nodesRole.Rules, "", "nodes", "list") {
+		t.Errorf("cluster role does not allow listing nodes: %v", nodesRole.Rules)
+	}
+}
@@ -0,0 +1,259 @@
+package master
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// masterServiceAccount is the service account the master runs as inside the
+// cluster. It is also the name of its Role and RoleBinding.
+const masterServiceAccount = "mapreduce-master"
+
+// Deploy prints the manifests that run the master configured by cfg inside
+// the cluster, so that a whole MapReduce job can be submitted with
+// kubectl apply.
+func Deploy(cfg *config.Config) {
+	if cfg.Image == "" {
+		logging.Fatal("Must provide image.")
+	}
+	for _, resources := range []config.TaskResources{cfg.MapperResources, cfg.ReducerResources} {
+		if err := validateResources(resources); err != nil {
+			logging.Fatal("Invalid task resources", "err", err)
+		}
+	}
+	if err := writeManifests(os.Stdout, deployManifests(cfg, time.Now())); err != nil {
+		logging.Fatal("Failed to write manifests", "err", err)
+	}
+}
+
+// deployManifests returns the service account and RBAC rules of the master
+// followed by the Kubernetes Job running it.
+func deployManifests(cfg *config.Config, now time.Time) []runtime.Object {
+	meta := metav1.ObjectMeta{Name: masterServiceAccount, Namespace: cfg.Namespace}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: masterServiceAccount, Namespace: cfg.Namespace}}
+	nodesRole := masterServiceAccount + "-nodes"
+
+	return []runtime.Object{
+		&v1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: meta,
+		},
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: meta,
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{"batch"},
+					Resources: []string{"jobs"},
+					Verbs:     []string{"create", "get", "list", "watch", "delete", "deletecollection"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"configmaps"},
+					Verbs:     []string{"create", "get", "delete"},
+				},
+			},
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: meta,
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: masterServiceAccount},
+		},
+		// Nodes are cluster scoped. The master counts them to validate the
+		// number of tasks.
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: nodesRole},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"nodes"},
+				Verbs:     []string{"list"},
+			}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: nodesRole},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: nodesRole},
+		},
+		createMasterJobSpec(cfg, now),
+	}
+}
+
+// createMasterJobSpec returns the Kubernetes Job running the master. It is
+// not retried, because a new master would start a new MapReduce job; use
+// --resume instead.
+func createMasterJobSpec(cfg *config.Config, now time.Time) *batchv1.Job {
+	labels := map[string]string{"app": "mapreduce-master"}
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mapreduce-master-" + now.Format("20060102-150405"),
+			Namespace: cfg.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ServiceAccountName: masterServiceAccount,
+					Containers: []v1.Container{
+						{
+							Name:         "master",
+							Image:        cfg.Image,
+							Command:      append([]string{"./mapreduce"}, masterArgs(cfg)...),
+							VolumeMounts: []v1.VolumeMount{nfsVolumeMount(cfg)},
+						},
+					},
+					Volumes:       []v1.Volume{nfsVolume(cfg)},
+					RestartPolicy: v1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+}
+
+// masterArgs returns the flags that configure the master like cfg.
+func masterArgs(cfg *config.Config) []string {
+	args := []string{
+		"--mode", "master",
+		"--image", cfg.Image,
+		"--input-dir", cfg.InputDir,
+		"--nfs-path", cfg.NfsPath,
+		"--num-mappers", strconv.Itoa(cfg.NumMappers),
+		"--num-reducers", strconv.Itoa(cfg.NumReducers),
+		"--log-level", cfg.LogLevel,
+		"--log-format", cfg.LogFormat,
+	}
+	optional := func(name, value string) {
+		if value != "" {
+			args = append(args, name, value)
+		}
+	}
+	optional("--resume", cfg.Resume)
+	optional("--mapper", cfg.MapperName)
+	optional("--reducer", cfg.ReducerName)
+	optional("--output-format", cfg.OutputFormatName)
+	optional("--input-format", cfg.InputFormatName)
+	for _, input := range cfg.Inputs {
+		args = append(args, "--input", config.FormatInput(input))
+	}
+	names := make([]string, 0, len(cfg.CacheFiles))
+	for name := range cfg.CacheFiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		args = append(args, "--cache-file", name+"="+cfg.CacheFiles[name])
+	}
+	if cfg.Timeout > 0 {
+		optional("--timeout", cfg.Timeout.String())
+	}
+	if cfg.DeleteIntermediate {
+		args = append(args, "--delete-intermediate")
+	}
+	optional("--http-addr", cfg.HttpAddr)
+	if cfg.WorkerMetricsPort > 0 {
+		optional("--worker-metrics-port", strconv.Itoa(cfg.WorkerMetricsPort))
+	}
+
+	for _, group := range []struct {
+		prefix    string
+		resources config.TaskResources
+	}{{"--mapper", cfg.MapperResources}, {"--reducer", cfg.ReducerResources}} {
+		optional(group.prefix+"-cpu-request", group.resources.CPURequest)
+		optional(group.prefix+"-memory-request", group.resources.MemoryRequest)
+		optional(group.prefix+"-cpu-limit", group.resources.CPULimit)
+		optional(group.prefix+"-memory-limit", group.resources.MemoryLimit)
+	}
+	keys := make([]string, 0, len(cfg.NodeSelector))
+	for key := range cfg.NodeSelector {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		args = append(args, "--node-selector", key+"="+cfg.NodeSelector[key])
+	}
+	for _, toleration := range cfg.Tolerations {
+		value := toleration.Key
+		if toleration.Operator != v1.TolerationOpExists {
+			value += "=" + toleration.Value
+		}
+		args = append(args, "--toleration", fmt.Sprintf("%s:%s", value, toleration.Effect))
+	}
+	if cfg.SpreadTasks {
+		args = append(args, "--spread-tasks")
+	}
+	optional("--priority-class", cfg.PriorityClassName)
+
+	args = append(args,
+		"--namespace", cfg.Namespace,
+		"--nfs-volume", cfg.NfsVolume,
+		"--nfs-claim", cfg.NfsClaim,
+	)
+	optional("--nfs-sub-path", cfg.NfsSubPath)
+	for _, env := range cfg.Env {
+		if env.ValueFrom != nil {
+			slog.Warn("Environment variable cannot be passed to the master", "name", env.Name)
+			continue
+		}
+		args = append(args, "--env", env.Name+"="+env.Value)
+	}
+	for _, source := range cfg.EnvFrom {
+		if source.SecretRef == nil {
+			slog.Warn("Environment source cannot be passed to the master", "source", source)
+			continue
+		}
+		args = append(args, "--env-secret", source.SecretRef.Name)
+	}
+	for i, volume := range cfg.Volumes {
+		value, ok := config.FormatVolume(volume, cfg.VolumeMounts[i])
+		if !ok {
+			slog.Warn("Volume cannot be passed to the master", "name", volume.Name)
+			continue
+		}
+		args = append(args, "--volume", value)
+	}
+	return args
+}
+
+// writeManifests writes objects as a multi-document YAML stream.
+func writeManifests(w io.Writer, objects []runtime.Object) error {
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
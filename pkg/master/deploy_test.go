@@ -0,0 +1,98 @@
+package master
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDeployManifests(t *testing.T) {
+	cfg := newSpecTestConfig()
+	cfg.NumMappers = 4
+	cfg.NumReducers = 2
+	cfg.Timeout = 2 * time.Hour
+	cfg.NodeSelector = map[string]string{"zone": "a", "disk": "ssd"}
+	cfg.Tolerations = []v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "mapreduce", Effect: v1.TaintEffectNoSchedule},
+		{Key: "spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+	}
+	cfg.Namespace = "team-a"
+	cfg.Env = []v1.EnvVar{{Name: "REGION", Value: "eu-west-1"}}
+	cfg.Volumes = []v1.Volume{{Name: "secret-api-keys", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "api-keys"}}}}
+	cfg.VolumeMounts = []v1.VolumeMount{{Name: "secret-api-keys", MountPath: "/etc/api-keys", ReadOnly: true}}
+	objects := deployManifests(cfg, time.Date(2024, 4, 21, 1, 7, 50, 0, time.UTC))
+
+	role := objects[1].(*rbacv1.Role)
+	if role.Namespace != "team-a" {
+		t.Errorf("role namespace = %q", role.Namespace)
+	}
+	jobsAllowed := slices.ContainsFunc(role.Rules, func(rule rbacv1.PolicyRule) bool {
+		return slices.Contains(rule.Resources, "jobs") && slices.Contains(rule.Verbs, "create") && slices.Contains(rule.Verbs, "watch")
+	})
+	if !jobsAllowed {
+		t.Errorf("role does not allow creating and watching jobs: %v", role.Rules)
+	}
+
+	job := objects[len(objects)-1].(*batchv1.Job)
+	if job.Name != "mapreduce-master-20240421-010750" {
+		t.Errorf("name = %q", job.Name)
+	}
+	pod := job.Spec.Template.Spec
+	if pod.ServiceAccountName != masterServiceAccount {
+		t.Errorf("service account = %q", pod.ServiceAccountName)
+	}
+	if *job.Spec.BackoffLimit != 0 {
+		t.Errorf("backoff limit = %d", *job.Spec.BackoffLimit)
+	}
+	command := strings.Join(pod.Containers[0].Command, " ")
+	for _, want := range []string{
+		"--mode master",
+		"--image mapreduce:test",
+		"--num-mappers 4",
+		"--num-reducers 2",
+		"--timeout 2h0m0s",
+		"--node-selector disk=ssd --node-selector zone=a",
+		"--toleration dedicated=mapreduce:NoSchedule --toleration spot:NoExecute",
+		"--namespace team-a",
+		"--env REGION=eu-west-1",
+		"--volume secret:api-keys:/etc/api-keys",
+	} {
+		if !strings.Contains(command, want) {
+			t.Errorf("command %q does not contain %q", command, want)
+		}
+	}
+}
+
+func TestWriteManifests(t *testing.T) {
+	cfg := newSpecTestConfig()
+	var out bytes.Buffer
+	if err := writeManifests(&out, deployManifests(cfg, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	documents := strings.Split(out.String(), "---\n")
+	if len(documents) != 6 {
+		t.Fatalf("expected 6 documents, got %d", len(documents))
+	}
+	kinds := make([]string, 0, len(documents))
+	for _, document := range documents {
+		var object struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			t.Fatal(err)
+		}
+		kinds = append(kinds, object.Kind)
+	}
+	want := []string{"ServiceAccount", "Role", "RoleBinding", "ClusterRole", "ClusterRoleBinding", "Job"}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %v, want %v", kinds, want)
+	}
+}
@@ -0,0 +1,224 @@
+package master
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createMapperJobSpec(cfg *config.Config, jobId, mapperId string, input config.Input, fileRange string, attempt int) *batchv1.Job {
+	args := []string{
+		"--mode", "mapper",
+		"--input-dir", input.Dir,
+		"--output-dir", mapperOutput(cfg, jobId, mapperId),
+		"--file-range", fileRange,
+		"--num-reducers", strconv.Itoa(cfg.NumReducers),
+	}
+	if input.Mapper != "" {
+		args = append(args, "--mapper", input.Mapper)
+	}
+	if input.Format != "" {
+		args = append(args, "--input-format", input.Format)
+	}
+	if input.Tag != "" {
+		args = append(args, "--input-tag", input.Tag)
+	}
+	if cfg.NumReducers == 0 && cfg.OutputFormatName != "" {
+		args = append(args, "--output-format", cfg.OutputFormatName)
+	}
+	if cfg.NumReducers > 0 && cfg.ReducerName != "" {
+		// The reducer decides how mappers partition and sort their output.
+		args = append(args, "--reducer", cfg.ReducerName)
+	}
+	return createWorkerJobSpec(cfg, jobId, "mapper", mapperId, attempt, cfg.MapperResources, args)
+}
+
+func createReducerJobSpec(cfg *config.Config, jobId string, reducerId, attempt int) *batchv1.Job {
+	reducerName := fmt.Sprintf("reducer-%d", reducerId)
+	inputDir := filepath.Join(cfg.NfsPath, jobId)
+	args := []string{
+		"--mode", "reducer",
+		"--input-dir", inputDir,
+		"--output-dir", outputDir(cfg.NfsPath, jobId),
+		"--reducer-id", strconv.Itoa(reducerId),
+	}
+	if cfg.ReducerName != "" {
+		args = append(args, "--reducer", cfg.ReducerName)
+	}
+	if cfg.OutputFormatName != "" {
+		args = append(args, "--output-format", cfg.OutputFormatName)
+	}
+	return createWorkerJobSpec(cfg, jobId, "reducer", reducerName, attempt, cfg.ReducerResources, args)
+}
+
+// mapperOutput is where a mapper commits its output: a directory of
+// partitions for the reducers or, in a map-only job, a file in the job's
+// output directory.
+func mapperOutput(cfg *config.Config, jobId, mapperId string) string {
+	if cfg.NumReducers == 0 {
+		return filepath.Join(outputDir(cfg.NfsPath, jobId), mapperId)
+	}
+	return filepath.Join(cfg.NfsPath, jobId, mapperId)
+}
+
+// outputDir is the directory reducers write the final output of a job to.
+// It holds only the reducer-N files, or the mapper-N files of a map-only job,
+// so it can be the input of another job.
+func outputDir(nfsPath, jobId string) string {
+	return filepath.Join(nfsPath, jobId, "output")
+}
+
+// createWorkerJobSpec returns the Kubernetes Job running one attempt of a
+// task. args select the mode of the worker; the flags shared by all tasks are
+// appended.
+func createWorkerJobSpec(cfg *config.Config, jobId, group, taskId string, attempt int, resources config.TaskResources, args []string) *batchv1.Job {
+	command := append([]string{"./mapreduce"}, args...)
+	command = append(command,
+		"--nfs-path", cfg.NfsPath,
+		"--job-id", jobId,
+		"--task-id", taskId,
+		"--attempt", strconv.Itoa(attempt),
+		"--log-level", cfg.LogLevel,
+		"--log-format", cfg.LogFormat,
+	)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesJobName(jobId, taskId, attempt),
+			Namespace: cfg.Namespace,
+			Labels:    taskLabels(jobId, group, taskId),
+		},
+		Spec: batchv1.JobSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: taskLabels(jobId, group, taskId),
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Name:         "worker",
+							Image:        cfg.Image,
+							Command:      command,
+							Resources:    resourceRequirements(resources),
+							Env:          cfg.Env,
+							EnvFrom:      cfg.EnvFrom,
+							VolumeMounts: append([]v1.VolumeMount{nfsVolumeMount(cfg)}, cfg.VolumeMounts...),
+						},
+					},
+					Volumes:           append([]v1.Volume{nfsVolume(cfg)}, cfg.Volumes...),
+					NodeSelector:      cfg.NodeSelector,
+					Tolerations:       cfg.Tolerations,
+					PriorityClassName: cfg.PriorityClassName,
+					RestartPolicy:     v1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+	if cfg.SpreadTasks {
+		job.Spec.Template.Spec.Affinity = spreadAffinity(jobId)
+	}
+	exposeWorkerMetrics(cfg, job)
+	return job
+}
+
+// nfsVolume is the shared volume holding the input, intermediate data and
+// output of all jobs.
+func nfsVolume(cfg *config.Config) v1.Volume {
+	return v1.Volume{
+		Name: cfg.NfsVolume,
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: cfg.NfsClaim,
+			},
+		},
+	}
+}
+
+// nfsVolumeMount mounts the shared volume at NfsPath, so that pods see the
+// same paths as the master.
+func nfsVolumeMount(cfg *config.Config) v1.VolumeMount {
+	return v1.VolumeMount{
+		Name:      cfg.NfsVolume,
+		MountPath: cfg.NfsPath,
+		SubPath:   cfg.NfsSubPath,
+	}
+}
+
+// resourceRequirements converts the configured resources to Kubernetes
+// requirements. The quantities are checked by validateResources.
+func resourceRequirements(resources config.TaskResources) v1.ResourceRequirements {
+	requirements := v1.ResourceRequirements{}
+	set := func(list *v1.ResourceList, name v1.ResourceName, value string) {
+		if value == "" {
+			return
+		}
+		if *list == nil {
+			*list = v1.ResourceList{}
+		}
+		(*list)[name] = resource.MustParse(value)
+	}
+	set(&requirements.Requests, v1.ResourceCPU, resources.CPURequest)
+	set(&requirements.Requests, v1.ResourceMemory, resources.MemoryRequest)
+	set(&requirements.Limits, v1.ResourceCPU, resources.CPULimit)
+	set(&requirements.Limits, v1.ResourceMemory, resources.MemoryLimit)
+	return requirements
+}
+
+// validateResources checks that all configured resources are valid quantities.
+func validateResources(resources config.TaskResources) error {
+	for _, value := range []string{resources.CPURequest, resources.MemoryRequest, resources.CPULimit, resources.MemoryLimit} {
+		if value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return fmt.Errorf("invalid resource quantity %q: %w", value, err)
+		}
+	}
+	return nil
+}
+
+// spreadAffinity prefers scheduling the pods of a job on nodes that do not
+// run another task of the same job yet.
+func spreadAffinity(jobId string) *v1.Affinity {
+	return &v1.Affinity{
+		PodAntiAffinity: &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: v1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{jobIdLabel: jobId},
+						},
+						TopologyKey: "kubernetes.io/hostname",
+					},
+				},
+			},
+		},
+	}
+}
+
+// exposeWorkerMetrics makes the worker serve /metrics on cfg.WorkerMetricsPort
+// and annotates its pod so that Prometheus scrapes it.
+func exposeWorkerMetrics(cfg *config.Config, job *batchv1.Job) {
+	if cfg.WorkerMetricsPort == 0 {
+		return
+	}
+	port := strconv.Itoa(cfg.WorkerMetricsPort)
+	job.Spec.Template.Annotations = map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   port,
+		"prometheus.io/path":   "/metrics",
+	}
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Command = append(container.Command, "--http-addr", ":"+port)
+	container.Ports = append(container.Ports, v1.ContainerPort{
+		Name:          "metrics",
+		ContainerPort: int32(cfg.WorkerMetricsPort),
+	})
+}
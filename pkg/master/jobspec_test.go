@@ -0,0 +1,192 @@
+package master
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newSpecTestConfig() *config.Config {
+	return &config.Config{
+		InputDir:    "/mnt/nfs/input",
+		NfsPath:     "/mnt/nfs",
+		Image:       "mapreduce:test",
+		NumReducers: 1,
+		LogLevel:    "info",
+		LogFormat:   "text",
+		Namespace:   "default",
+		NfsVolume:   "nfs-storage",
+		NfsClaim:    "nfs-pvc",
+	}
+}
+
+func TestMapperJobSpec(t *testing.T) {
+	cfg := newSpecTestConfig()
+	job := createMapperJobSpec(cfg, "job-1", "mapper-2", config.Input{Dir: cfg.InputDir}, "book-0-9", 1)
+
+	if job.Name != "job-1-mapper-2-1" {
+		t.Errorf("name = %q", job.Name)
+	}
+	if job.Labels[jobIdLabel] != "job-1" || job.Labels[taskIdLabel] != "mapper-2" {
+		t.Errorf("unexpected labels %v", job.Labels)
+	}
+	pod := job.Spec.Template.Spec
+	if pod.RestartPolicy != v1.RestartPolicyNever {
+		t.Errorf("restart policy = %q", pod.RestartPolicy)
+	}
+	command := pod.Containers[0].Command
+	for _, want := range [][]string{
+		{"--mode", "mapper"},
+		{"--file-range", "book-0-9"},
+		{"--output-dir", "/mnt/nfs/job-1/mapper-2"},
+		{"--attempt", "1"},
+	} {
+		i := slices.Index(command, want[0])
+		if i < 0 || i+1 >= len(command) || command[i+1] != want[1] {
+			t.Errorf("command %v does not contain %v", command, want)
+		}
+	}
+
+	// Without configuration no scheduling constraints are set.
+	if pod.Affinity != nil || pod.NodeSelector != nil || pod.Tolerations != nil || pod.PriorityClassName != "" {
+		t.Errorf("unexpected scheduling constraints: %+v", pod)
+	}
+	if pod.Containers[0].Resources.Requests != nil || pod.Containers[0].Resources.Limits != nil {
+		t.Errorf("unexpected resources: %+v", pod.Containers[0].Resources)
+	}
+
+	// Mappers partition and sort their output as the reducer requests.
+	cfg.ReducerName = "events-by-user"
+	command = createMapperJobSpec(cfg, "job-1", "mapper-2", config.Input{Dir: cfg.InputDir}, "book-0-9", 1).Spec.Template.Spec.Containers[0].Command
+	if got := commandArg(command, "--reducer"); got != "events-by-user" {
+		t.Errorf("reducer = %q", got)
+	}
+}
+
+func TestMapOnlyMapperJobSpec(t *testing.T) {
+	cfg := newSpecTestConfig()
+	cfg.NumReducers = 0
+	job := createMapperJobSpec(cfg, "job-1", "mapper-2", config.Input{Dir: cfg.InputDir}, "book-0-9", 0)
+
+	command := job.Spec.Template.Spec.Containers[0].Command
+	if got := commandArg(command, "--output-dir"); got != "/mnt/nfs/job-1/output/mapper-2" {
+		t.Errorf("output dir = %q", got)
+	}
+	if got := commandArg(command, "--num-reducers"); got != "0" {
+		t.Errorf("num reducers = %q", got)
+	}
+
+	cfg.OutputFormatName = "jsonl"
+	command = createMapperJobSpec(cfg, "job-1", "mapper-2", config.Input{Dir: cfg.InputDir}, "book-0-9", 0).Spec.Template.Spec.Containers[0].Command
+	if got := commandArg(command, "--output-format"); got != "jsonl" {
+		t.Errorf("output format = %q", got)
+	}
+}
+
+func TestJobSpecScheduling(t *testing.T) {
+	cfg := newSpecTestConfig()
+	cfg.MapperResources = config.TaskResources{CPURequest: "500m", MemoryRequest: "256Mi", MemoryLimit: "512Mi"}
+	cfg.ReducerResources = config.TaskResources{CPURequest: "2", CPULimit: "4"}
+	cfg.NodeSelector = map[string]string{"disk": "ssd"}
+	cfg.Tolerations = []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "batch", Effect: v1.TaintEffectNoSchedule}}
+	cfg.SpreadTasks = true
+	cfg.PriorityClassName = "batch-low"
+
+	mapper := createMapperJobSpec(cfg, "job-1", "mapper-0", config.Input{Dir: cfg.InputDir}, "book-0-9", 0)
+	reducer := createReducerJobSpec(cfg, "job-1", 0, 0)
+
+	mapperResources := mapper.Spec.Template.Spec.Containers[0].Resources
+	assertQuantity(t, mapperResources.Requests, v1.ResourceCPU, "500m")
+	assertQuantity(t, mapperResources.Requests, v1.ResourceMemory, "256Mi")
+	assertQuantity(t, mapperResources.Limits, v1.ResourceMemory, "512Mi")
+	if _, ok := mapperResources.Limits[v1.ResourceCPU]; ok {
+		t.Error("mapper has a CPU limit although none was configured")
+	}
+	reducerResources := reducer.Spec.Template.Spec.Containers[0].Resources
+	assertQuantity(t, reducerResources.Requests, v1.ResourceCPU, "2")
+	assertQuantity(t, reducerResources.Limits, v1.ResourceCPU, "4")
+
+	for _, pod := range []*v1.PodSpec{&mapper.Spec.Template.Spec, &reducer.Spec.Template.Spec} {
+		if pod.NodeSelector["disk"] != "ssd" {
+			t.Errorf("node selector = %v", pod.NodeSelector)
+		}
+		if len(pod.Tolerations) != 1 || pod.Tolerations[0].Key != "dedicated" {
+			t.Errorf("tolerations = %v", pod.Tolerations)
+		}
+		if pod.PriorityClassName != "batch-low" {
+			t.Errorf("priority class = %q", pod.PriorityClassName)
+		}
+		if pod.Affinity == nil || pod.Affinity.PodAntiAffinity == nil {
+			t.Fatal("missing pod anti-affinity")
+		}
+		term := pod.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm
+		if term.TopologyKey != "kubernetes.io/hostname" || term.LabelSelector.MatchLabels[jobIdLabel] != "job-1" {
+			t.Errorf("unexpected anti-affinity term %+v", term)
+		}
+	}
+}
+
+func TestJobSpecPodConfiguration(t *testing.T) {
+	cfg := newSpecTestConfig()
+	cfg.Namespace = "team-a"
+	cfg.NfsVolume = "data"
+	cfg.NfsClaim = "team-a-data"
+	cfg.NfsSubPath = "mapreduce"
+	cfg.Env = []v1.EnvVar{{Name: "REGION", Value: "eu-west-1"}}
+	cfg.EnvFrom = []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "api-keys"}}}}
+	volume, mount, err := config.ParseVolume("configmap:stopwords:/etc/stopwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Volumes = []v1.Volume{volume}
+	cfg.VolumeMounts = []v1.VolumeMount{mount}
+
+	for _, job := range []*batchv1.Job{
+		createMapperJobSpec(cfg, "job-1", "mapper-0", config.Input{Dir: cfg.InputDir}, "book-0-9", 0),
+		createReducerJobSpec(cfg, "job-1", 0, 0),
+	} {
+		if job.Namespace != "team-a" {
+			t.Errorf("namespace = %q", job.Namespace)
+		}
+		pod := job.Spec.Template.Spec
+		if len(pod.Volumes) != 2 || pod.Volumes[0].Name != "data" || pod.Volumes[0].PersistentVolumeClaim.ClaimName != "team-a-data" || pod.Volumes[1].ConfigMap == nil {
+			t.Errorf("volumes = %+v", pod.Volumes)
+		}
+		container := pod.Containers[0]
+		wantMounts := []v1.VolumeMount{{Name: "data", MountPath: "/mnt/nfs", SubPath: "mapreduce"}, mount}
+		if !slices.Equal(container.VolumeMounts, wantMounts) {
+			t.Errorf("volume mounts = %+v, want %+v", container.VolumeMounts, wantMounts)
+		}
+		if len(container.Env) != 1 || container.Env[0].Name != "REGION" {
+			t.Errorf("env = %v", container.Env)
+		}
+		if len(container.EnvFrom) != 1 || container.EnvFrom[0].SecretRef.Name != "api-keys" {
+			t.Errorf("env from = %v", container.EnvFrom)
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	if err := validateResources(config.TaskResources{CPURequest: "500m", MemoryLimit: "1Gi"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateResources(config.TaskResources{MemoryRequest: "lots"}); err == nil {
+		t.Error("expected an error for an invalid quantity")
+	}
+}
+
+func assertQuantity(t *testing.T, list v1.ResourceList, name v1.ResourceName, want string) {
+	t.Helper()
+	got, ok := list[name]
+	if !ok {
+		t.Errorf("%s not set", name)
+		return
+	}
+	if got.Cmp(resource.MustParse(want)) != 0 {
+		t.Errorf("%s = %s, want %s", name, got.String(), want)
+	}
+}
@@ -2,274 +2,501 @@ package master
 
 import (
 	"context"
-	"flag"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/MichalPitr/map_reduce/pkg/cache"
 	"github.com/MichalPitr/map_reduce/pkg/config"
-	batchv1 "k8s.io/api/batch/v1"
-	v1 "k8s.io/api/core/v1"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
+// Run runs a MapReduce job on the cluster configured by cfg.Kubeconfig and
+// exits when the job fails.
 func Run(cfg *config.Config) {
-	clientset := createKubernetesClient()
-	numNodes := getNumberOfNodes(clientset)
-	mustValidateConfig(cfg, numNodes)
-
-	jobId := fmt.Sprintf("job-%s", time.Now().Format("2006-01-02-15-04-05"))
-	log.Printf("Running master: %s", jobId)
+	// Cancelling on Ctrl-C makes the master delete the job's Kubernetes Jobs
+	// instead of leaving them running.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
 
-	mustCreateJobDir(cfg.NfsPath, jobId)
-	fileRanges := partitionInputFiles(cfg.InputDir, cfg.NumMappers)
+	clientset, err := createKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	if err := RunWithClient(ctx, cfg, clientset); err != nil {
+		logging.Fatal("Job failed", "err", err)
+	}
+}
 
-	t0 := time.Now()
-	launchMappers(cfg, clientset, jobId, fileRanges)
-	waitForJobsToComplete(clientset, jobId, "mapper")
-	log.Printf("Mappers took %v to finish", time.Since(t0))
-
-	t1 := time.Now()
-	launchReducers(cfg, clientset, jobId)
-	waitForJobsToComplete(clientset, jobId, "reducer")
-	log.Printf("Reducers took %v to finish", time.Since(t1))
-	log.Printf("Total runtime: %v", time.Since(t0))
+// RunWithClient runs a MapReduce job using the given Kubernetes client. The
+// Kubernetes Jobs are deleted when the job fails or ctx is cancelled.
+func RunWithClient(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface) error {
+	return run(ctx, cfg, clientset, newJobStatus(""))
 }
 
-func mustCreateJobDir(path string, jobId string) {
-	jobDir := filepath.Join(path, jobId)
-	err := os.Mkdir(jobDir, 0777)
+// run runs a MapReduce job and reports its progress to status.
+func run(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, status *jobStatus) error {
+	numNodes, err := getNumberOfNodes(ctx, clientset)
 	if err != nil {
-		log.Fatalf("Error creating job directory: %v", err)
+		return fmt.Errorf("listing nodes: %w", err)
 	}
-}
 
-func mustValidateConfig(cfg *config.Config, numNodes int) {
-	if numNodes == 0 {
-		log.Fatal("Need at least 1 node in the cluster.")
-	} else if numNodes < cfg.NumMappers || numNodes < cfg.NumReducers {
-		log.Fatal("More mappers or reducers than available nodes.")
+	var checkpoint *jobCheckpoint
+	var jobId string
+	if cfg.Resume != "" {
+		jobId = cfg.Resume
+		cp, err := loadCheckpoint(filepath.Join(cfg.NfsPath, jobId))
+		if err != nil {
+			return fmt.Errorf("loading job state of %s: %w", jobId, err)
+		}
+		checkpoint = cp
+		checkpoint.applyTo(cfg)
+		if err := validateConfig(cfg, numNodes); err != nil {
+			return err
+		}
+	} else {
+		if err := validateConfig(cfg, numNodes); err != nil {
+			return err
+		}
+		fileRanges, inputTags, err := planMappers(cfg)
+		if err != nil {
+			return err
+		}
+		jobId = newJobId()
+		if err := createJobDir(cfg.NfsPath, jobId); err != nil {
+			return err
+		}
+		checkpoint = newCheckpoint(cfg, jobId, fileRanges, inputTags)
 	}
+	jobDir := filepath.Join(cfg.NfsPath, jobId)
 
-	if cfg.Image == "" {
-		log.Fatal("Must provide image.")
+	cfg.JobId = jobId
+	cfg.TaskId = "master"
+	if err := logging.Setup(cfg); err != nil {
+		return fmt.Errorf("setting up job logging: %w", err)
 	}
-}
+	slog.Info("Running master", "resume", cfg.Resume != "")
 
-func partitionInputFiles(inputDir string, partitions int) []string {
-	entries, err := os.ReadDir(inputDir)
-	if err != nil {
-		log.Printf("Failed to read contents of %s, error: %v", inputDir, err)
-		os.Exit(1)
+	status.setJobId(jobId)
+	if cfg.HttpAddr != "" {
+		mux := http.NewServeMux()
+		registerStatusHandlers(mux, status, cfg.NfsPath)
+		addr, err := metrics.Serve(cfg.HttpAddr, mux)
+		if err != nil {
+			return fmt.Errorf("starting HTTP server: %w", err)
+		}
+		slog.Info("Serving job status and metrics", "addr", addr)
 	}
-	files := make([]string, 0)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+
+	if cfg.Resume != "" {
+		// Attempts launched before the master was interrupted are replaced
+		// by new ones, so they must not be counted when waiting.
+		if err := deleteKubernetesJobs(ctx, clientset, cfg.Namespace, jobId); err != nil {
+			return fmt.Errorf("deleting Kubernetes jobs of the previous run: %w", err)
 		}
-		files = append(files, entry.Name())
+		checkpoint.refresh()
+	}
+	if err := checkpoint.save(jobDir); err != nil {
+		return fmt.Errorf("saving job state: %w", err)
+	}
+	if err := stageCache(cfg, jobDir); err != nil {
+		return err
 	}
 
-	slices.Sort(files)
-	fileRanges := make([]string, partitions)
-	filesInPartition := len(files) / partitions
+	owner, err := createJobOwner(ctx, clientset, cfg.Namespace, jobId)
+	if err != nil {
+		return err
+	}
 
-	extra := len(files) % partitions
-	currentStart := 0
-	for i := 0; i < partitions; i++ {
-		currentEnd := currentStart + filesInPartition - 1
-		if extra > 0 {
-			currentEnd++
-			extra--
+	t0 := time.Now()
+	status.setPhase("map")
+	checkpoint.Phase = "map"
+	if err := launchMappers(ctx, cfg, clientset, status, owner, checkpoint, jobId); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, err)
+	}
+	if err := checkpoint.save(jobDir); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, fmt.Errorf("saving job state: %w", err))
+	}
+	if err := waitForJobsToComplete(ctx, clientset, cfg, status, jobId, "mapper"); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, err)
+	}
+	if err := finishPhase(checkpoint, jobDir, "mapper", len(checkpoint.FileRanges)); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, err)
+	}
+	mapperDuration := time.Since(t0)
+	metrics.PhaseDuration.WithLabelValues("map").Set(mapperDuration.Seconds())
+	slog.Info("Mappers finished", "duration", mapperDuration)
+
+	var reducerDuration time.Duration
+	if cfg.NumReducers > 0 {
+		t1 := time.Now()
+		status.setPhase("reduce")
+		checkpoint.Phase = "reduce"
+		if err := launchReducers(ctx, cfg, clientset, status, owner, checkpoint, jobId); err != nil {
+			return abortJob(clientset, cfg.Namespace, jobId, err)
+		}
+		if err := checkpoint.save(jobDir); err != nil {
+			return abortJob(clientset, cfg.Namespace, jobId, fmt.Errorf("saving job state: %w", err))
+		}
+		if err := waitForJobsToComplete(ctx, clientset, cfg, status, jobId, "reducer"); err != nil {
+			return abortJob(clientset, cfg.Namespace, jobId, err)
+		}
+		if err := finishPhase(checkpoint, jobDir, "reducer", cfg.NumReducers); err != nil {
+			return abortJob(clientset, cfg.Namespace, jobId, err)
 		}
-		first := strings.Split(files[currentStart], "-")
-		last := strings.Split(files[currentEnd], "-")
-		fileRange := first[0] + "-" + first[1] + "-" + last[1]
-		fileRanges[i] = fileRange
+		reducerDuration = time.Since(t1)
+		metrics.PhaseDuration.WithLabelValues("reduce").Set(reducerDuration.Seconds())
+		slog.Info("Reducers finished", "duration", reducerDuration)
+	} else {
+		// Map-only jobs have no shuffle; the mappers wrote the final output.
+		slog.Info("Skipping reduce phase of map-only job")
+	}
+	slog.Info("Job finished", "duration", time.Since(t0))
 
-		currentStart = currentEnd + 1
+	summary := jobSummary{
+		JobId:           jobId,
+		MapperDuration:  mapperDuration,
+		ReducerDuration: reducerDuration,
+		TotalDuration:   time.Since(t0),
+	}
+	if err := writeJobSummary(jobDir, summary); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, err)
+	}
+	status.setPhase("done")
+	checkpoint.Phase = "done"
+	if err := checkpoint.save(jobDir); err != nil {
+		return abortJob(clientset, cfg.Namespace, jobId, fmt.Errorf("saving job state: %w", err))
 	}
 
-	return fileRanges
+	if err := deleteKubernetesJobs(ctx, clientset, cfg.Namespace, jobId); err != nil {
+		slog.Warn("Failed to delete Kubernetes jobs", "err", err)
+	}
+	if cfg.DeleteIntermediate {
+		if err := deleteIntermediateData(cfg.NfsPath, jobId); err != nil {
+			slog.Warn("Failed to delete intermediate data", "err", err)
+		}
+	}
+	return nil
 }
 
-func createKubernetesClient() *kubernetes.Clientset {
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+// finishPhase verifies that all tasks of a phase committed their output and
+// persists the progress.
+func finishPhase(checkpoint *jobCheckpoint, jobDir, prefix string, numTasks int) error {
+	checkpoint.refresh()
+	if err := checkpoint.save(jobDir); err != nil {
+		return fmt.Errorf("saving job state: %w", err)
 	}
-	flag.Parse()
+	if missing := checkpoint.uncommitted(prefix, numTasks); len(missing) > 0 {
+		return fmt.Errorf("tasks finished without committed output: %v", missing)
+	}
+	return nil
+}
+
+// jobSummary is saved to the job directory once the job completes.
+type jobSummary struct {
+	JobId           string           `json:"jobId"`
+	MapperDuration  time.Duration    `json:"mapperDuration"`
+	ReducerDuration time.Duration    `json:"reducerDuration"`
+	TotalDuration   time.Duration    `json:"totalDuration"`
+	Counters        map[string]int64 `json:"counters"`
+}
 
-	// Use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+// writeJobSummary aggregates the counters of all tasks, logs them and saves
+// the summary as summary.json in the job directory.
+func writeJobSummary(jobDir string, summary jobSummary) error {
+	total, err := counters.Aggregate(jobDir)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("aggregating counters: %w", err)
 	}
+	summary.Counters = total.Snapshot()
 
-	// Create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	names := make([]string, 0, len(summary.Counters))
+	for name := range summary.Counters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		slog.Info("Counter", "name", name, "value", summary.Counters[name])
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("encoding job summary: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "summary.json"), data, 0644); err != nil {
+		return fmt.Errorf("writing job summary: %w", err)
 	}
-	return clientset
+	return nil
 }
 
-func getNumberOfNodes(clientset *kubernetes.Clientset) int {
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+func createJobDir(path string, jobId string) error {
+	jobDir := filepath.Join(path, jobId)
+	if err := os.Mkdir(jobDir, 0777); err != nil {
+		return fmt.Errorf("creating job directory: %w", err)
+	}
+	if err := os.Mkdir(counters.Dir(jobDir), 0777); err != nil {
+		return fmt.Errorf("creating counters directory: %w", err)
+	}
+	return nil
+}
+
+func validateConfig(cfg *config.Config, numNodes int) error {
+	if cfg.NumMappers < 1 {
+		return errors.New("need at least 1 mapper")
+	} else if cfg.NumReducers < 0 {
+		return errors.New("number of reducers cannot be negative")
+	}
+	if numNodes == 0 {
+		return errors.New("need at least 1 node in the cluster")
+	} else if numNodes < numMapperTasks(cfg) || numNodes < cfg.NumReducers {
+		return fmt.Errorf("more mappers or reducers than available nodes (%d)", numNodes)
+	}
+
+	if cfg.Image == "" {
+		return errors.New("must provide image")
+	}
+	if _, ok := cfg.Mappers[cfg.MapperName]; cfg.MapperName != "" && !ok {
+		return fmt.Errorf("unknown mapper %q", cfg.MapperName)
+	}
+	if _, ok := cfg.LookupInputFormat(cfg.InputFormatName); cfg.InputFormatName != "" && !ok {
+		return fmt.Errorf("unknown input format %q", cfg.InputFormatName)
+	}
+	if err := validateInputs(cfg); err != nil {
+		return err
+	}
+	if cfg.ReducerName != "" && !cfg.HasReducer(cfg.ReducerName) {
+		return fmt.Errorf("unknown reducer %q", cfg.ReducerName)
+	}
+	if _, ok := cfg.LookupOutputFormat(cfg.OutputFormatName); cfg.OutputFormatName != "" && !ok {
+		return fmt.Errorf("unknown output format %q", cfg.OutputFormatName)
+	}
+	for _, resources := range []config.TaskResources{cfg.MapperResources, cfg.ReducerResources} {
+		if err := validateResources(resources); err != nil {
+			return fmt.Errorf("invalid task resources: %w", err)
+		}
+	}
+	return nil
+}
+
+// stageCache copies the cache files of the job to the job directory, unless a
+// previous run of a resumed job already did.
+func stageCache(cfg *config.Config, jobDir string) error {
+	dir := filepath.Join(jobDir, cache.DirName)
+	if len(cfg.CacheFiles) == 0 || cache.Staged(dir) {
+		return nil
+	}
+	manifest, err := cache.Stage(dir, cfg.CacheFiles)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("staging cache files: %w", err)
 	}
-	return len(nodes.Items)
+	slog.Info("Staged cache files", "checksums", manifest)
+	return nil
 }
 
-func launchMappers(cfg *config.Config, clientset *kubernetes.Clientset, jobId string, fileRanges []string) {
-	for i := 0; i < cfg.NumMappers; i++ {
-		mapperId := fmt.Sprintf("mapper-%d", i)
-		_ = clientset
-		log.Printf("Creating %s for %s", mapperId, fileRanges[i])
-		job := createMapperJobSpec(cfg, jobId, mapperId, fileRanges[i])
-		_, err := clientset.BatchV1().Jobs("default").Create(context.TODO(), job, metav1.CreateOptions{})
-		if err != nil {
-			log.Printf("Failed to create a job: %v", err)
-			os.Exit(1)
+// validateInputs checks the tagged inputs of a job, which replace its input
+// directory.
+func validateInputs(cfg *config.Config) error {
+	if len(cfg.Inputs) == 0 {
+		return nil
+	}
+	if cfg.InputDir != "" {
+		return errors.New("cannot use both an input dir and tagged inputs")
+	}
+	tags := make(map[string]bool, len(cfg.Inputs))
+	for _, input := range cfg.Inputs {
+		if input.Tag == "" || input.Dir == "" {
+			return fmt.Errorf("input %q must have a tag and a dir", config.FormatInput(input))
+		}
+		if tags[input.Tag] {
+			return fmt.Errorf("duplicate input tag %q", input.Tag)
+		}
+		tags[input.Tag] = true
+		if _, ok := cfg.Mappers[input.Mapper]; input.Mapper != "" && !ok {
+			return fmt.Errorf("unknown mapper %q of input %s", input.Mapper, input.Tag)
+		}
+		if _, ok := cfg.LookupInputFormat(input.Format); input.Format != "" && !ok {
+			return fmt.Errorf("unknown input format %q of input %s", input.Format, input.Tag)
 		}
 	}
+	return nil
+}
+
+// numMapperTasks returns the number of mappers planMappers launches.
+func numMapperTasks(cfg *config.Config) int {
+	return cfg.NumMappers * max(1, len(cfg.Inputs))
 }
 
-func waitForJobsToComplete(clientset *kubernetes.Clientset, jobName, suffix string) {
-	labelSelector := fmt.Sprintf("job-group=%s-%s", jobName, suffix)
-	for {
-		jobs, err := clientset.BatchV1().Jobs("default").List(context.TODO(), metav1.ListOptions{
-			LabelSelector: labelSelector,
-		})
+// planMappers returns the file range of every mapper and, for jobs with
+// tagged inputs, the tag of the input it reads. Each input is split between
+// NumMappers mappers.
+func planMappers(cfg *config.Config) (fileRanges, inputTags []string, err error) {
+	if len(cfg.Inputs) == 0 {
+		fileRanges, err := partitionInputFiles(cfg.InputDir, cfg.NumMappers)
+		return fileRanges, nil, err
+	}
+	for _, input := range cfg.Inputs {
+		ranges, err := partitionInputFiles(input.Dir, cfg.NumMappers)
 		if err != nil {
-			log.Printf("Failed to list jobs: %v", err)
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("input %s: %w", input.Tag, err)
+		}
+		for _, fileRange := range ranges {
+			fileRanges = append(fileRanges, fileRange)
+			inputTags = append(inputTags, input.Tag)
 		}
+	}
+	return fileRanges, inputTags, nil
+}
 
-		allCompleted := true
-		for _, job := range jobs.Items {
-			if job.Status.Succeeded == 0 {
-				allCompleted = false
-				break
-			}
+// partitionInputFiles splits the files in inputDir into contiguous ranges of
+// the form prefix-start-end, one per mapper. Input files must be named
+// prefix-N with consecutive numbers, like the reducer-N output of a previous
+// job. Hidden files and files starting with "_" are ignored.
+func partitionInputFiles(inputDir string, partitions int) ([]string, error) {
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading input dir: %w", err)
+	}
+	prefix := ""
+	numbers := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		i := strings.LastIndex(name, "-")
+		n, err := strconv.Atoi(name[i+1:])
+		if i <= 0 || err != nil || n < 0 {
+			return nil, fmt.Errorf("input file %q is not named prefix-N", name)
+		}
+		if prefix == "" {
+			prefix = name[:i]
+		} else if name[:i] != prefix {
+			return nil, fmt.Errorf("input files have different prefixes %q and %q", prefix, name[:i])
 		}
+		numbers = append(numbers, n)
+	}
+	if len(numbers) < partitions {
+		return nil, fmt.Errorf("%d input files cannot be split between %d mappers", len(numbers), partitions)
+	}
 
-		if allCompleted {
-			log.Println("All jobs completed.")
-			break
+	slices.Sort(numbers)
+	for i, n := range numbers {
+		if n != numbers[0]+i {
+			return nil, fmt.Errorf("input file %s-%d is missing", prefix, numbers[0]+i)
 		}
+	}
 
-		log.Println("Waiting for jobs to finish.")
-		time.Sleep(10 * time.Second)
+	fileRanges := make([]string, partitions)
+	filesInPartition := len(numbers) / partitions
+	extra := len(numbers) % partitions
+	currentStart := 0
+	for i := 0; i < partitions; i++ {
+		currentEnd := currentStart + filesInPartition - 1
+		if extra > 0 {
+			currentEnd++
+			extra--
+		}
+		fileRanges[i] = fmt.Sprintf("%s-%d-%d", prefix, numbers[currentStart], numbers[currentEnd])
+		currentStart = currentEnd + 1
 	}
+	return fileRanges, nil
 }
 
-func createMapperJobSpec(cfg *config.Config, jobId, mapperId, fileRange string) *batchv1.Job {
-	outputDir := filepath.Join(cfg.NfsPath, jobId, mapperId)
-	return &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      mapperId,
-			Namespace: "default",
-			Labels: map[string]string{
-				"job-group": jobId + "-mapper",
-			},
-		},
-		Spec: batchv1.JobSpec{
-			Template: v1.PodTemplateSpec{
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Name:    "worker",
-							Image:   cfg.Image,
-							Command: []string{"./mapreduce", "--mode", "mapper", "--input-dir", cfg.InputDir, "--output-dir", outputDir, "--file-range", fileRange},
-							VolumeMounts: []v1.VolumeMount{
-								{
-									Name:      "nfs-storage",
-									MountPath: cfg.NfsPath,
-								},
-							},
-						},
-					},
-					Volumes: []v1.Volume{
-						{
-							Name: "nfs-storage",
-							VolumeSource: v1.VolumeSource{
-								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: "nfs-pvc",
-								},
-							},
-						},
-					},
-					RestartPolicy: v1.RestartPolicyNever,
-				},
-			},
-		},
+// createKubernetesClient creates a client for the cluster the master runs in.
+// Outside of a cluster, it uses the current context in kubeconfig.
+func createKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := restConfig(kubeconfig)
+	if err != nil {
+		return nil, err
 	}
+	return kubernetes.NewForConfig(config)
 }
 
-func launchReducers(cfg *config.Config, clientset *kubernetes.Clientset, jobId string) {
-	for i := 0; i < cfg.NumReducers; i++ {
-		log.Printf("Creating reducer-%d", i)
-		job := createReducerJobSpec(cfg, jobId, i)
-		_, err := clientset.BatchV1().Jobs("default").Create(context.TODO(), job, metav1.CreateOptions{})
+func restConfig(kubeconfig string) (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if errors.Is(err, rest.ErrNotInCluster) {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return config, err
+}
+
+func getNumberOfNodes(ctx context.Context, clientset kubernetes.Interface) (int, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return len(nodes.Items), nil
+}
+
+// launchMappers creates a Kubernetes Job for every mapper that has not
+// committed its output yet.
+func launchMappers(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, status *jobStatus, owner *metav1.OwnerReference, checkpoint *jobCheckpoint, jobId string) error {
+	for i, fileRange := range checkpoint.FileRanges {
+		mapperId := fmt.Sprintf("mapper-%d", i)
+		input := checkpoint.mapperInput(cfg, i)
+		split := fileRange
+		if input.Tag != "" {
+			split = input.Tag + ":" + fileRange
+		}
+		if checkpoint.committed(mapperId) {
+			slog.Info("Skipping committed mapper", "mapperId", mapperId)
+			status.addTask(mapperId, "map", split, "committed")
+			continue
+		}
+		attempt := checkpoint.nextAttempt(mapperId)
+		slog.Info("Creating mapper", "mapperId", mapperId, "inputTag", input.Tag, "fileRange", fileRange, "attempt", attempt)
+		job := createMapperJobSpec(cfg, jobId, mapperId, input, fileRange, attempt)
+		job.OwnerReferences = []metav1.OwnerReference{*owner}
+		_, err := clientset.BatchV1().Jobs(cfg.Namespace).Create(ctx, job, metav1.CreateOptions{})
 		if err != nil {
-			log.Fatalf("Failed to create a job: %v", err)
+			return fmt.Errorf("creating %s: %w", mapperId, err)
 		}
+		status.addTask(mapperId, "map", split, "pending")
 	}
+	return nil
 }
 
-func createReducerJobSpec(cfg *config.Config, jobId string, reducerId int) *batchv1.Job {
-	reducerName := fmt.Sprintf("reducer-%d", reducerId)
-	inputDir := filepath.Join(cfg.NfsPath, jobId)
-	outputDir := filepath.Join(cfg.NfsPath, jobId)
-	return &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      reducerName,
-			Namespace: "default",
-			Labels: map[string]string{
-				"job-group": jobId + "-reducer",
-			},
-		},
-		Spec: batchv1.JobSpec{
-			Template: v1.PodTemplateSpec{
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Name:    "worker",
-							Image:   cfg.Image,
-							Command: []string{"./mapreduce", "--mode", "reducer", "--input-dir", inputDir, "--output-dir", outputDir, "--reducer-id", strconv.Itoa(reducerId)},
-							VolumeMounts: []v1.VolumeMount{
-								{
-									Name:      "nfs-storage",
-									MountPath: cfg.NfsPath,
-								},
-							},
-						},
-					},
-					Volumes: []v1.Volume{
-						{
-							Name: "nfs-storage",
-							VolumeSource: v1.VolumeSource{
-								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: "nfs-pvc",
-								},
-							},
-						},
-					},
-					RestartPolicy: v1.RestartPolicyNever,
-				},
-			},
-		},
+// launchReducers creates a Kubernetes Job for every reducer that has not
+// committed its output yet.
+func launchReducers(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, status *jobStatus, owner *metav1.OwnerReference, checkpoint *jobCheckpoint, jobId string) error {
+	for i := 0; i < cfg.NumReducers; i++ {
+		reducerName := fmt.Sprintf("reducer-%d", i)
+		inputSplit := fmt.Sprintf("partition-%d", i)
+		if checkpoint.committed(reducerName) {
+			slog.Info("Skipping committed reducer", "reducerId", i)
+			status.addTask(reducerName, "reduce", inputSplit, "committed")
+			continue
+		}
+		attempt := checkpoint.nextAttempt(reducerName)
+		slog.Info("Creating reducer", "reducerId", i, "attempt", attempt)
+		job := createReducerJobSpec(cfg, jobId, i, attempt)
+		job.OwnerReferences = []metav1.OwnerReference{*owner}
+		_, err := clientset.BatchV1().Jobs(cfg.Namespace).Create(ctx, job, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", job.Name, err)
+		}
+		status.addTask(reducerName, "reduce", inputSplit, "pending")
 	}
+	return nil
 }
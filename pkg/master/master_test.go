@@ -0,0 +1,544 @@
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/cache"
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeCluster runs the Kubernetes Jobs created by the master against a fake
+// clientset. Instead of starting pods, it commits the output and counters of
+// each task and marks its Job as succeeded, or as failed for the tasks in fail.
+type fakeCluster struct {
+	t      *testing.T
+	client *fake.Clientset
+	fail   map[string]bool
+
+	mu       sync.Mutex
+	launched []*batchv1.Job
+	// watched holds the label selectors of the Job watches. The fake
+	// clientset does not replay events missed between listing and watching,
+	// so tasks only finish once the master watches them.
+	watched map[string]bool
+}
+
+func newFakeCluster(t *testing.T, numNodes int, fail ...string) *fakeCluster {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	for i := 0; i < numNodes; i++ {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}
+		if _, err := client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fake clientset does not implement DeleteCollection. Reactors run
+	// with the clientset locked, so they must use the object tracker.
+	client.PrependReactor("delete-collection", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.DeleteCollectionAction).GetListRestrictions().Labels
+		list, err := client.Tracker().List(action.GetResource(), batchv1.SchemeGroupVersion.WithKind("Job"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		for _, job := range list.(*batchv1.JobList).Items {
+			if !selector.Matches(labels.Set(job.Labels)) {
+				continue
+			}
+			if err := client.Tracker().Delete(action.GetResource(), job.Namespace, job.Name); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, nil
+	})
+
+	c := &fakeCluster{t: t, client: client, fail: make(map[string]bool), watched: make(map[string]bool)}
+	for _, taskId := range fail {
+		c.fail[taskId] = true
+	}
+	client.PrependWatchReactor("jobs", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.watched[action.(k8stesting.WatchAction).GetWatchRestrictions().Labels.String()] = true
+		return false, nil, nil
+	})
+
+	w, err := client.BatchV1().Jobs(metav1.NamespaceAll).Watch(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+	go func() {
+		for event := range w.ResultChan() {
+			if event.Type == watch.Added {
+				c.runTask(event.Object.(*batchv1.Job))
+			}
+		}
+	}()
+	return c
+}
+
+func (c *fakeCluster) runTask(job *batchv1.Job) {
+	c.mu.Lock()
+	c.launched = append(c.launched, job)
+	c.mu.Unlock()
+	c.waitUntilWatched("job-group=" + job.Labels["job-group"])
+
+	command := job.Spec.Template.Spec.Containers[0].Command
+	taskId := commandArg(command, "--task-id")
+	jobDir := filepath.Join(commandArg(command, "--nfs-path"), commandArg(command, "--job-id"))
+
+	var status batchv1.JobStatus
+	if c.fail[taskId] {
+		status = batchv1.JobStatus{
+			Failed: 1,
+			Conditions: []batchv1.JobCondition{{
+				Type:   batchv1.JobFailed,
+				Status: v1.ConditionTrue,
+				Reason: "BackoffLimitExceeded",
+			}},
+		}
+	} else {
+		if err := commitTaskOutput(command); err != nil {
+			c.t.Error(err)
+		}
+		taskCounters := counters.New()
+		taskCounters.Add(counters.MapInputRecords, 10)
+		if err := taskCounters.WriteFile(counters.TaskPath(jobDir, taskId)); err != nil {
+			c.t.Error(err)
+		}
+		status = batchv1.JobStatus{Succeeded: 1}
+	}
+
+	job.Status = status
+	if _, err := c.client.BatchV1().Jobs(job.Namespace).UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+		c.t.Error(err)
+	}
+}
+
+// commitTaskOutput commits the partition directory of a mapper or the output
+// file of a reducer or map-only mapper, which holds one word,count record.
+func commitTaskOutput(command []string) error {
+	output := commandArg(command, "--output-dir")
+	if commandArg(command, "--mode") == "reducer" {
+		output = filepath.Join(output, commandArg(command, "--task-id"))
+	}
+	temp := commit.TempPath(output, 0)
+	if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+		return err
+	}
+	var err error
+	if commandArg(command, "--mode") == "mapper" && commandArg(command, "--num-reducers") != "0" {
+		err = os.MkdirAll(temp, 0777)
+	} else {
+		err = os.WriteFile(temp, []byte("words,1\n"), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = commit.Commit(temp, output)
+	return err
+}
+
+func (c *fakeCluster) waitUntilWatched(selector string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		watched := c.watched[selector]
+		c.mu.Unlock()
+		if watched {
+			return
+		}
+	}
+	c.t.Errorf("jobs with %s are not watched", selector)
+}
+
+// launchedTasks returns the task ids and attempts of all launched Jobs, e.g.
+// mapper-0/0.
+func (c *fakeCluster) launchedTasks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tasks := make([]string, 0, len(c.launched))
+	for _, job := range c.launched {
+		command := job.Spec.Template.Spec.Containers[0].Command
+		tasks = append(tasks, commandArg(command, "--task-id")+"/"+commandArg(command, "--attempt"))
+	}
+	slices.Sort(tasks)
+	return tasks
+}
+
+func commandArg(command []string, name string) string {
+	i := slices.Index(command, name)
+	if i < 0 || i+1 >= len(command) {
+		return ""
+	}
+	return command[i+1]
+}
+
+func newRunTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	inputDir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		if err := os.WriteFile(filepath.Join(inputDir, "book-"+strconv.Itoa(i)), []byte("words"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &config.Config{
+		InputDir:    inputDir,
+		NfsPath:     t.TempDir(),
+		Image:       "mapreduce:test",
+		NumMappers:  2,
+		NumReducers: 2,
+		LogLevel:    "info",
+		LogFormat:   "text",
+		Namespace:   "default",
+		NfsVolume:   "nfs-storage",
+		NfsClaim:    "nfs-pvc",
+	}
+}
+
+func runJob(t *testing.T, cfg *config.Config, cluster *fakeCluster) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return RunWithClient(ctx, cfg, cluster.client)
+}
+
+func TestRunJob(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cfg.Namespace = "team-a"
+	cluster := newFakeCluster(t, 2)
+
+	if err := runJob(t, cfg, cluster); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+
+	want := []string{"mapper-0/0", "mapper-1/0", "reducer-0/0", "reducer-1/0"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	for _, job := range cluster.launched {
+		taskId := job.Labels[taskIdLabel]
+		if job.Name != kubernetesJobName(cfg.JobId, taskId, 0) || job.Namespace != cfg.Namespace {
+			t.Errorf("job %s/%s", job.Namespace, job.Name)
+		}
+		if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].Kind != "ConfigMap" || job.OwnerReferences[0].Name != cfg.JobId {
+			t.Errorf("%s: unexpected owner references %v", taskId, job.OwnerReferences)
+		}
+		command := job.Spec.Template.Spec.Containers[0].Command
+		if taskId == "mapper-1" && commandArg(command, "--file-range") != "book-2-3" {
+			t.Errorf("mapper-1 command = %v", command)
+		}
+		if taskId == "reducer-1" && commandArg(command, "--reducer-id") != "1" {
+			t.Errorf("reducer-1 command = %v", command)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.JobDir(), "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary jobSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if got := summary.Counters[counters.MapInputRecords]; got != 40 {
+		t.Errorf("%s = %d, want 40", counters.MapInputRecords, got)
+	}
+
+	checkpoint, err := loadCheckpoint(cfg.JobDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Phase != "done" {
+		t.Errorf("phase = %q", checkpoint.Phase)
+	}
+
+	// The Kubernetes Jobs and their owner are deleted once the job succeeded.
+	jobs, err := cluster.client.BatchV1().Jobs(cfg.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("%d Kubernetes jobs left", len(jobs.Items))
+	}
+	_, err = cluster.client.CoreV1().ConfigMaps(cfg.Namespace).Get(context.Background(), cfg.JobId, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected job owner to be deleted, got %v", err)
+	}
+}
+
+func TestRunJobFailedMapper(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2, "mapper-1")
+
+	err := runJob(t, cfg, cluster)
+	if err == nil || !strings.Contains(err.Error(), "mapper-1") {
+		t.Fatalf("expected mapper-1 to fail the job, got %v", err)
+	}
+
+	// No reducers are launched and the mappers are cleaned up.
+	want := []string{"mapper-0/0", "mapper-1/0"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+	jobs, err := cluster.client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("%d Kubernetes jobs left", len(jobs.Items))
+	}
+}
+
+func TestRunJobResume(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	if err := runJob(t, cfg, newFakeCluster(t, 2, "reducer-0")); err == nil {
+		t.Fatal("expected reducer-0 to fail the job")
+	}
+
+	resumed := &config.Config{
+		NfsPath:   cfg.NfsPath,
+		Resume:    cfg.JobId,
+		LogLevel:  "info",
+		LogFormat: "text",
+		Namespace: "default",
+		NfsVolume: "nfs-storage",
+		NfsClaim:  "nfs-pvc",
+	}
+	cluster := newFakeCluster(t, 2)
+	if err := runJob(t, resumed, cluster); err != nil {
+		t.Fatalf("resumed job failed: %v", err)
+	}
+
+	// Only the failed reducer is launched again, with a new attempt.
+	want := []string{"reducer-0/1"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+	if resumed.Image != "mapreduce:test" {
+		t.Errorf("image = %q, want it restored from the job state", resumed.Image)
+	}
+}
+
+func TestRunJobMapOnly(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cfg.NumReducers = 0
+	cluster := newFakeCluster(t, 2)
+
+	if err := runJob(t, cfg, cluster); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+
+	// No reducers are launched and the mappers write the final output.
+	want := []string{"mapper-0/0", "mapper-1/0"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+	files, err := partitionInputFiles(outputDir(cfg.NfsPath, cfg.JobId), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(files, []string{"mapper-0-1"}) {
+		t.Errorf("output files = %v", files)
+	}
+	checkpoint, err := loadCheckpoint(cfg.JobDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Phase != "done" {
+		t.Errorf("phase = %q", checkpoint.Phase)
+	}
+}
+
+func TestRunJobMultipleInputs(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	usersDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(filepath.Join(usersDir, "users-"+strconv.Itoa(i)), []byte("1,ann"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Inputs = []config.Input{
+		{Tag: "books", Dir: cfg.InputDir},
+		{Tag: "users", Dir: usersDir, Mapper: "users", Format: "csv"},
+	}
+	cfg.InputDir = ""
+	cfg.MapperName = "wordcount"
+	cfg.InputFormatName = "text"
+	cfg.RegisterMapper("wordcount", nil)
+	cfg.RegisterMapper("users", nil)
+	cluster := newFakeCluster(t, 4)
+
+	if err := runJob(t, cfg, cluster); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+
+	// Each input is split between the mappers of the job and read by its
+	// own mapper and input format.
+	want := []string{"mapper-0/0", "mapper-1/0", "mapper-2/0", "mapper-3/0", "reducer-0/0", "reducer-1/0"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+	wantArgs := map[string][]string{
+		"mapper-0": {"books", "wordcount", "text", cfg.Inputs[0].Dir, "book-0-1"},
+		"mapper-1": {"books", "wordcount", "text", cfg.Inputs[0].Dir, "book-2-3"},
+		"mapper-2": {"users", "users", "csv", usersDir, "users-0-0"},
+		"mapper-3": {"users", "users", "csv", usersDir, "users-1-1"},
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	for _, job := range cluster.launched {
+		want, ok := wantArgs[job.Labels[taskIdLabel]]
+		if !ok {
+			continue
+		}
+		command := job.Spec.Template.Spec.Containers[0].Command
+		got := []string{
+			commandArg(command, "--input-tag"),
+			commandArg(command, "--mapper"),
+			commandArg(command, "--input-format"),
+			commandArg(command, "--input-dir"),
+			commandArg(command, "--file-range"),
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: input args %v, want %v", job.Labels[taskIdLabel], got, want)
+		}
+	}
+}
+
+func TestRunJobStagesCache(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	stopwords := filepath.Join(t.TempDir(), "stopwords.txt")
+	if err := os.WriteFile(stopwords, []byte("the\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CacheFiles = map[string]string{"stopwords": stopwords}
+
+	if err := runJob(t, cfg, newFakeCluster(t, 2)); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	dir := filepath.Join(cfg.JobDir(), cache.DirName)
+	if !cache.Staged(dir) {
+		t.Fatal("cache files were not staged")
+	}
+	if _, err := cache.Localize(dir, t.TempDir()); err != nil {
+		t.Errorf("staged cache cannot be localized: %v", err)
+	}
+	checkpoint, err := loadCheckpoint(cfg.JobDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.CacheFiles["stopwords"] != stopwords {
+		t.Errorf("checkpointed cache files = %v", checkpoint.CacheFiles)
+	}
+}
+
+func TestValidateInputs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    config.Config
+		errMsg string
+	}{
+		{"input dir", config.Config{InputDir: "/in", Inputs: []config.Input{{Tag: "a", Dir: "/a"}}}, "both an input dir and tagged inputs"},
+		{"duplicate tag", config.Config{Inputs: []config.Input{{Tag: "a", Dir: "/a"}, {Tag: "a", Dir: "/b"}}}, "duplicate input tag"},
+		{"missing dir", config.Config{Inputs: []config.Input{{Tag: "a"}}}, "must have a tag and a dir"},
+		{"unknown mapper", config.Config{Inputs: []config.Input{{Tag: "a", Dir: "/a", Mapper: "nope"}}}, "unknown mapper"},
+		{"unknown format", config.Config{Inputs: []config.Input{{Tag: "a", Dir: "/a", Format: "parquet"}}}, "unknown input format"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateInputs(&tc.cfg)
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRunJobValidation(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cfg.NumMappers = 3
+
+	err := runJob(t, cfg, newFakeCluster(t, 2))
+	if err == nil || !strings.Contains(err.Error(), "more mappers or reducers than available nodes") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+
+	// Every tagged input is split between NumMappers mappers.
+	cfg = newRunTestConfig(t)
+	cfg.NumMappers = 2
+	cfg.Inputs = []config.Input{{Tag: "books", Dir: cfg.InputDir}, {Tag: "users", Dir: t.TempDir()}}
+	cfg.InputDir = ""
+	err = runJob(t, cfg, newFakeCluster(t, 3))
+	if err == nil || !strings.Contains(err.Error(), "more mappers or reducers than available nodes") {
+		t.Errorf("expected validation error for tagged inputs, got %v", err)
+	}
+}
+
+func TestPartitionInputFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		files      []string
+		partitions int
+		want       []string
+		err        string
+	}{
+		{name: "even", files: []string{"book-0", "book-1", "book-2", "book-3"}, partitions: 2, want: []string{"book-0-1", "book-2-3"}},
+		{name: "uneven", files: []string{"book-0", "book-1", "book-2"}, partitions: 2, want: []string{"book-0-1", "book-2-2"}},
+		// Sorting names would put book-10 before book-2.
+		{name: "numeric order", files: []string{"book-8", "book-9", "book-10", "book-11"}, partitions: 2, want: []string{"book-8-9", "book-10-11"}},
+		{name: "reducer output", files: []string{"reducer-0", "reducer-1", ".hidden", "_SUCCESS"}, partitions: 1, want: []string{"reducer-0-1"}},
+		{name: "dashed prefix", files: []string{"part-r-0", "part-r-1"}, partitions: 2, want: []string{"part-r-0-0", "part-r-1-1"}},
+		{name: "missing file", files: []string{"book-0", "book-2"}, partitions: 1, err: "book-1 is missing"},
+		{name: "mixed prefixes", files: []string{"book-0", "news-1"}, partitions: 1, err: "different prefixes"},
+		{name: "no number", files: []string{"book"}, partitions: 1, err: "not named prefix-N"},
+		{name: "too few files", files: []string{"book-0"}, partitions: 2, err: "cannot be split between 2 mappers"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			for _, name := range tc.files {
+				if err := os.WriteFile(filepath.Join(inputDir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Mkdir(filepath.Join(inputDir, "_temporary"), 0777); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := partitionInputFiles(inputDir, tc.partitions)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package master
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// taskIdLabel identifies the task, e.g. mapper-0, a Kubernetes Job or pod runs.
+const taskIdLabel = "mapreduce/task-id"
+
+// newJobId returns a job id that is unique even for jobs started within the
+// same second. It is used for the job directory, labels and as the prefix of
+// all Kubernetes object names.
+func newJobId() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("job-%s-%s", time.Now().Format("2006-01-02-15-04-05"), hex.EncodeToString(suffix))
+}
+
+// kubernetesJobName returns the name of the Kubernetes Job running an attempt
+// of a task, e.g. job-2024-04-21-01-07-50-a1b2c3-mapper-0-0.
+func kubernetesJobName(jobId, taskId string, attempt int) string {
+	return fmt.Sprintf("%s-%s-%d", jobId, taskId, attempt)
+}
+
+// taskLabels returns the labels set on the Kubernetes Job and pods of a task.
+func taskLabels(jobId, group, taskId string) map[string]string {
+	return map[string]string{
+		"job-group": jobId + "-" + group,
+		jobIdLabel:  jobId,
+		taskIdLabel: taskId,
+	}
+}
+
+// createJobOwner creates the ConfigMap that owns all Kubernetes Jobs of a
+// MapReduce job, so that deleting it garbage collects the Jobs and their pods.
+func createJobOwner(ctx context.Context, clientset kubernetes.Interface, namespace, jobId string) (*metav1.OwnerReference, error) {
+	owner := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobId,
+			Namespace: namespace,
+			Labels:    map[string]string{jobIdLabel: jobId},
+		},
+		Data: map[string]string{"jobId": jobId},
+	}
+	owner, err := clientset.CoreV1().ConfigMaps(namespace).Create(ctx, owner, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating job owner: %w", err)
+	}
+	return &metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package master
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"github.com/MichalPitr/map_reduce/pkg/pipeline"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunPipeline runs the stages of the pipeline spec in cfg.Pipeline in
+// dependency order and exits when a stage fails. Settings that are not part
+// of a stage, e.g. the image, are taken from cfg.
+func RunPipeline(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	spec, err := pipeline.Load(cfg.Pipeline)
+	if err != nil {
+		logging.Fatal("Failed to load pipeline", "err", err)
+	}
+	clientset, err := createKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		logging.Fatal("Failed to create Kubernetes client", "err", err)
+	}
+	if _, err := runPipeline(ctx, cfg, clientset, spec); err != nil {
+		logging.Fatal("Pipeline failed", "err", err)
+	}
+}
+
+// runPipeline runs each stage of spec as a MapReduce job. A stage that reads
+// another stage gets the reducer output of its job as input directory, or as
+// the directory of a tagged input. It returns the output directory of every
+// stage.
+func runPipeline(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, spec *pipeline.Spec) (map[string]string, error) {
+	stages, err := spec.Order()
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]string, len(stages))
+	formats := make(map[string]string, len(stages))
+	for _, stage := range stages {
+		stageCfg := *cfg
+		stageCfg.InputDir = stage.InputDir
+		stageCfg.Inputs = nil
+		if stage.Input != "" {
+			stageCfg.InputDir = outputs[stage.Input]
+			stageCfg.InputFormatName = formats[stage.Input]
+		}
+		for _, input := range stage.Inputs {
+			tagged := config.Input{Tag: input.Tag, Dir: input.Dir, Mapper: input.Mapper, Format: input.Format}
+			if input.Stage != "" {
+				tagged.Dir = outputs[input.Stage]
+				if tagged.Format == "" {
+					tagged.Format = formats[input.Stage]
+				}
+			}
+			stageCfg.Inputs = append(stageCfg.Inputs, tagged)
+		}
+		if stage.InputFormat != "" {
+			stageCfg.InputFormatName = stage.InputFormat
+		}
+		stageCfg.MapperName = stage.Mapper
+		stageCfg.ReducerName = stage.Reducer
+		stageCfg.NumMappers = stage.NumMappers
+		stageCfg.NumReducers = stage.NumReducers
+		if stage.OutputFormat != "" {
+			stageCfg.OutputFormatName = stage.OutputFormat
+		}
+		stageCfg.JobId, stageCfg.TaskId, stageCfg.Resume = "", "", ""
+		// Each stage would start its own server on the same address.
+		stageCfg.HttpAddr = ""
+
+		slog.Info("Running pipeline stage", "pipeline", spec.Name, "stage", stage.Name, "inputDir", stageCfg.InputDir)
+		err := run(ctx, &stageCfg, clientset, newJobStatus(""))
+		// Stages log to their own job directory; switch back to the
+		// pipeline log.
+		if err := logging.Setup(cfg); err != nil {
+			slog.Warn("Failed to reset logging", "err", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		outputs[stage.Name] = outputDir(stageCfg.NfsPath, stageCfg.JobId)
+		formats[stage.Name] = stageCfg.OutputFormatName
+		slog.Info("Pipeline stage finished", "stage", stage.Name, "jobId", stageCfg.JobId, "outputDir", outputs[stage.Name])
+	}
+	return outputs, nil
+}
@@ -0,0 +1,167 @@
+package master
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/pipeline"
+)
+
+func newPipelineTestSpec(inputDir string) *pipeline.Spec {
+	return &pipeline.Spec{
+		Name: "word-frequencies",
+		Stages: []pipeline.Stage{
+			{Name: "frequencies", Input: "count", Mapper: "frequency", Reducer: "sum", NumMappers: 2, NumReducers: 1},
+			{Name: "count", InputDir: inputDir, Mapper: "wordcount", Reducer: "sum", NumMappers: 2, NumReducers: 2},
+		},
+	}
+}
+
+func runTestPipeline(t *testing.T, cfg *config.Config, spec *pipeline.Spec, cluster *fakeCluster) (map[string]string, error) {
+	t.Helper()
+	cfg.RegisterMapper("wordcount", nil)
+	cfg.RegisterMapper("frequency", nil)
+	cfg.RegisterReducer("sum", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return runPipeline(ctx, cfg, cluster.client, spec)
+}
+
+func TestRunPipeline(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2)
+
+	spec := newPipelineTestSpec(cfg.InputDir)
+	spec.Stages[1].OutputFormat = "tsv"
+	outputs, err := runTestPipeline(t, cfg, spec, cluster)
+	if err != nil {
+		t.Fatalf("pipeline failed: %v", err)
+	}
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	if len(cluster.launched) != 7 {
+		t.Fatalf("launched %d tasks, want 7", len(cluster.launched))
+	}
+	// The count stage runs first, then its reducer output is mapped by the
+	// frequencies stage.
+	countJobId := cluster.launched[0].Labels[jobIdLabel]
+	frequenciesJobId := cluster.launched[6].Labels[jobIdLabel]
+	if outputs["count"] != filepath.Join(cfg.NfsPath, countJobId, "output") ||
+		outputs["frequencies"] != filepath.Join(cfg.NfsPath, frequenciesJobId, "output") {
+		t.Errorf("outputs = %v", outputs)
+	}
+	for _, job := range cluster.launched {
+		command := job.Spec.Template.Spec.Containers[0].Command
+		if commandArg(command, "--mode") != "mapper" {
+			continue
+		}
+		switch job.Labels[jobIdLabel] {
+		case countJobId:
+			if commandArg(command, "--input-dir") != cfg.InputDir || commandArg(command, "--mapper") != "wordcount" {
+				t.Errorf("count mapper command = %v", command)
+			}
+		case frequenciesJobId:
+			if commandArg(command, "--input-dir") != outputs["count"] || commandArg(command, "--mapper") != "frequency" {
+				t.Errorf("frequencies mapper command = %v", command)
+			}
+			if fileRange := commandArg(command, "--file-range"); fileRange != "reducer-0-0" && fileRange != "reducer-1-1" {
+				t.Errorf("frequencies file range = %q", fileRange)
+			}
+			// The output of the count stage is read in its format.
+			if got := commandArg(command, "--input-format"); got != "tsv" {
+				t.Errorf("frequencies input format = %q", got)
+			}
+		}
+	}
+}
+
+func TestRunPipelineStopsOnFailure(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2, "reducer-1")
+
+	_, err := runTestPipeline(t, cfg, newPipelineTestSpec(cfg.InputDir), cluster)
+	if err == nil || !strings.HasPrefix(err.Error(), "stage count:") {
+		t.Fatalf("expected the count stage to fail, got %v", err)
+	}
+	// The frequencies stage is not started.
+	want := []string{"mapper-0/0", "mapper-1/0", "reducer-0/0", "reducer-1/0"}
+	if got := cluster.launchedTasks(); !slices.Equal(got, want) {
+		t.Errorf("launched %v, want %v", got, want)
+	}
+}
+
+func TestRunPipelineUnknownMapper(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cluster := newFakeCluster(t, 2)
+	spec := newPipelineTestSpec(cfg.InputDir)
+	spec.Stages[1].Mapper = "wordcounter"
+
+	_, err := runTestPipeline(t, cfg, spec, cluster)
+	if err == nil || !strings.Contains(err.Error(), `unknown mapper "wordcounter"`) {
+		t.Fatalf("expected unknown mapper error, got %v", err)
+	}
+	if len(cluster.launchedTasks()) != 0 {
+		t.Errorf("launched %v", cluster.launchedTasks())
+	}
+}
+
+func TestRunPipelineJoinsStages(t *testing.T) {
+	cfg := newRunTestConfig(t)
+	cfg.RegisterMapper("top", nil)
+	cfg.RegisterReducer("top", nil)
+	cfg.RegisterMapper("tagged", nil)
+	cfg.RegisterReducer("join", nil)
+	cluster := newFakeCluster(t, 2)
+	spec := &pipeline.Spec{
+		Name: "top-words",
+		Stages: []pipeline.Stage{
+			{Name: "join", Inputs: []pipeline.Input{{Tag: "counts", Stage: "count"}, {Tag: "top", Stage: "top"}},
+				Mapper: "tagged", Reducer: "join", NumMappers: 1, NumReducers: 1},
+			{Name: "top", Input: "count", Mapper: "top", Reducer: "top", NumMappers: 2, NumReducers: 1, OutputFormat: "jsonl"},
+			{Name: "count", InputDir: cfg.InputDir, Mapper: "wordcount", Reducer: "sum", NumMappers: 2, NumReducers: 2, OutputFormat: "tsv"},
+		},
+	}
+	outputs, err := runTestPipeline(t, cfg, spec, cluster)
+	if err != nil {
+		t.Fatalf("pipeline failed: %v", err)
+	}
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	// count runs 2 mappers and 2 reducers, top 2 mappers and a reducer and
+	// join a mapper for each input and a reducer.
+	if len(cluster.launched) != 10 {
+		t.Fatalf("launched %d tasks, want 10", len(cluster.launched))
+	}
+	joinJobId := cluster.launched[9].Labels[jobIdLabel]
+	if outputs["join"] != filepath.Join(cfg.NfsPath, joinJobId, "output") {
+		t.Errorf("outputs = %v", outputs)
+	}
+	// Each tagged input reads the output of its stage in that stage's
+	// output format.
+	want := map[string][]string{
+		"mapper-0": {"counts", outputs["count"], "tsv"},
+		"mapper-1": {"top", outputs["top"], "jsonl"},
+	}
+	for _, job := range cluster.launched {
+		command := job.Spec.Template.Spec.Containers[0].Command
+		if job.Labels[jobIdLabel] != joinJobId || commandArg(command, "--mode") != "mapper" {
+			continue
+		}
+		taskId := job.Labels[taskIdLabel]
+		got := []string{commandArg(command, "--input-tag"), commandArg(command, "--input-dir"), commandArg(command, "--input-format")}
+		if !slices.Equal(got, want[taskId]) {
+			t.Errorf("%s: input args %v, want %v", taskId, got, want[taskId])
+		}
+		delete(want, taskId)
+	}
+	if len(want) != 0 {
+		t.Errorf("join mappers %v were not launched", want)
+	}
+}
@@ -0,0 +1,240 @@
+package master
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// maxRecentJobs limits how many completed jobs the status API lists.
+const maxRecentJobs = 20
+
+// taskStatus is the live state of a single mapper or reducer.
+type taskStatus struct {
+	Id             string           `json:"id"`
+	Phase          string           `json:"phase"`
+	State          string           `json:"state"`
+	Attempts       int32            `json:"attempts"`
+	StartTime      *time.Time       `json:"startTime,omitempty"`
+	CompletionTime *time.Time       `json:"completionTime,omitempty"`
+	Runtime        time.Duration    `json:"runtime"`
+	Node           string           `json:"node,omitempty"`
+	InputSplit     string           `json:"inputSplit"`
+	Counters       map[string]int64 `json:"counters,omitempty"`
+}
+
+// jobStatus is the live state of the running job that the master serves over HTTP.
+type jobStatus struct {
+	mu        sync.Mutex
+	JobId     string        `json:"jobId"`
+	Phase     string        `json:"phase"`
+	StartTime time.Time     `json:"startTime"`
+	Tasks     []*taskStatus `json:"tasks"`
+
+	// changed receives a value after every change that has not been
+	// consumed yet.
+	changed chan struct{}
+}
+
+func newJobStatus(jobId string) *jobStatus {
+	return &jobStatus{JobId: jobId, Phase: "pending", StartTime: time.Now(), changed: make(chan struct{}, 1)}
+}
+
+// notify signals a change without blocking. Must be called with the lock held.
+func (s *jobStatus) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *jobStatus) setJobId(jobId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.JobId = jobId
+	s.notify()
+}
+
+func (s *jobStatus) setPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Phase = phase
+	s.notify()
+}
+
+// summary returns the job id, the phase and the number of tasks in each state.
+func (s *jobStatus) summary() (string, string, map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := make(map[string]int)
+	for _, task := range s.Tasks {
+		tasks[task.State]++
+	}
+	return s.JobId, s.Phase, tasks
+}
+
+// addTask registers a task once its Kubernetes Job has been created, or as
+// committed when a resumed job skips it.
+func (s *jobStatus) addTask(id, phase, inputSplit, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Tasks = append(s.Tasks, &taskStatus{Id: id, Phase: phase, State: state, InputSplit: inputSplit})
+	s.notify()
+}
+
+// updateTask refreshes a task from the status of its Kubernetes Job and the
+// node its latest pod was scheduled on.
+func (s *jobStatus) updateTask(id string, job *batchv1.Job, node string, taskCounters map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, task := range s.Tasks {
+		if task.Id != id {
+			continue
+		}
+		task.State = jobState(job)
+		task.Attempts = job.Status.Active + job.Status.Succeeded + job.Status.Failed
+		if node != "" {
+			task.Node = node
+		}
+		if taskCounters != nil {
+			task.Counters = taskCounters
+		}
+		if job.Status.StartTime != nil {
+			start := job.Status.StartTime.Time
+			task.StartTime = &start
+			task.Runtime = time.Since(start)
+		}
+		if job.Status.CompletionTime != nil {
+			completion := job.Status.CompletionTime.Time
+			task.CompletionTime = &completion
+			if task.StartTime != nil {
+				task.Runtime = completion.Sub(*task.StartTime)
+			}
+		}
+	}
+	s.notify()
+}
+
+// MarshalJSON takes the lock so the status can be encoded while tasks are updated.
+func (s *jobStatus) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type status jobStatus
+	return json.Marshal((*status)(s))
+}
+
+// recentJobs returns summaries of completed jobs in nfsPath, newest first.
+func recentJobs(nfsPath string) ([]jobSummary, error) {
+	entries, err := os.ReadDir(nfsPath)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "job-") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	// Job ids start with a timestamp, so reverse order is newest first.
+	slices.Sort(ids)
+	slices.Reverse(ids)
+
+	summaries := make([]jobSummary, 0)
+	for _, id := range ids {
+		data, err := os.ReadFile(filepath.Join(nfsPath, id, "summary.json"))
+		if err != nil {
+			// Jobs without a summary are still running or have failed.
+			continue
+		}
+		var summary jobSummary
+		if err := json.Unmarshal(data, &summary); err != nil {
+			slog.Warn("Skipping invalid job summary", "jobId", id, "err", err)
+			continue
+		}
+		summaries = append(summaries, summary)
+		if len(summaries) == maxRecentJobs {
+			break
+		}
+	}
+	return summaries, nil
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Second) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="5">
+<title>{{.Job.JobId}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Job.JobId}}</h1>
+<p>Phase: <b>{{.Job.Phase}}</b>, started {{.Job.StartTime.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<tr><th>Task</th><th>Phase</th><th>State</th><th>Attempts</th><th>Runtime</th><th>Node</th><th>Input split</th><th>Counters</th></tr>
+{{range .Job.Tasks}}<tr><td>{{.Id}}</td><td>{{.Phase}}</td><td>{{.State}}</td><td>{{.Attempts}}</td><td>{{round .Runtime}}</td><td>{{.Node}}</td><td>{{.InputSplit}}</td><td>{{range $name, $value := .Counters}}{{$name}}={{$value}}<br>{{end}}</td></tr>
+{{end}}</table>
+<h2>Recent jobs</h2>
+<table>
+<tr><th>Job</th><th>Mappers</th><th>Reducers</th><th>Total</th></tr>
+{{range .Recent}}<tr><td>{{.JobId}}</td><td>{{round .MapperDuration}}</td><td>{{round .ReducerDuration}}</td><td>{{round .TotalDuration}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// registerStatusHandlers serves the status page on / and the JSON API on
+// /api/job and /api/jobs.
+func registerStatusHandlers(mux *http.ServeMux, status *jobStatus, nfsPath string) {
+	mux.HandleFunc("/api/job", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		summaries, err := recentJobs(nfsPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, summaries)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		summaries, err := recentJobs(nfsPath)
+		if err != nil {
+			slog.Warn("Failed to list recent jobs", "err", err)
+		}
+		status.mu.Lock()
+		defer status.mu.Unlock()
+		data := struct {
+			Job    *jobStatus
+			Recent []jobSummary
+		}{status, summaries}
+		if err := statusPage.Execute(w, data); err != nil {
+			slog.Warn("Failed to render status page", "err", err)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "err", err)
+	}
+}
@@ -0,0 +1,93 @@
+package master
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStatusHandlers(t *testing.T) {
+	nfsPath := t.TempDir()
+	for _, id := range []string{"job-2024-01-01-00-00-00", "job-2024-01-02-00-00-00"} {
+		if err := os.Mkdir(filepath.Join(nfsPath, id), 0777); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(jobSummary{JobId: id, TotalDuration: time.Minute})
+		if err := os.WriteFile(filepath.Join(nfsPath, id, "summary.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A job that is still running has no summary.
+	if err := os.Mkdir(filepath.Join(nfsPath, "job-2024-01-03-00-00-00"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	status := newJobStatus("job-2024-01-03-00-00-00")
+	status.setPhase("map")
+	status.addTask("mapper-0", "map", "book-0-9", "pending")
+	start := metav1.NewTime(time.Now().Add(-time.Minute))
+	job := &batchv1.Job{Status: batchv1.JobStatus{Active: 1, Failed: 1, StartTime: &start}}
+	status.updateTask("mapper-0", job, "node-1", nil)
+
+	mux := http.NewServeMux()
+	registerStatusHandlers(mux, status, nfsPath)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var gotJob struct {
+		JobId string
+		Phase string
+		Tasks []taskStatus
+	}
+	getJSON(t, server.URL+"/api/job", &gotJob)
+	if gotJob.Phase != "map" || len(gotJob.Tasks) != 1 {
+		t.Fatalf("unexpected job status: %+v", gotJob)
+	}
+	task := gotJob.Tasks[0]
+	if task.State != "active" || task.Attempts != 2 || task.Node != "node-1" || task.InputSplit != "book-0-9" {
+		t.Errorf("unexpected task status: %+v", task)
+	}
+
+	var gotJobs []jobSummary
+	getJSON(t, server.URL+"/api/jobs", &gotJobs)
+	if len(gotJobs) != 2 || gotJobs[0].JobId != "job-2024-01-02-00-00-00" {
+		t.Errorf("expected two completed jobs, newest first, got %+v", gotJobs)
+	}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "mapper-0") {
+		t.Error("status page does not list mapper-0")
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
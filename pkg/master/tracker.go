@@ -0,0 +1,268 @@
+package master
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// progressInterval is how often the tracker logs progress while nothing changes.
+const progressInterval = 30 * time.Second
+
+// waitingGracePeriod is how long a container may stay in a transient waiting
+// reason before the job fails.
+const waitingGracePeriod = 5 * time.Minute
+
+// waitingReasons are container waiting reasons that keep a task from
+// starting. Fatal reasons, marked true, will not resolve by retrying, so the
+// job fails immediately instead of waiting for a timeout. The others are
+// often transient, e.g. a registry hiccup, which the kubelet reports as
+// ErrImagePull and then ImagePullBackOff within seconds, or a Secret that is
+// still being created. They fail the job only once they persist past
+// waitingGracePeriod.
+var waitingReasons = map[string]bool{
+	"InvalidImageName":           true,
+	"ErrImagePull":               false,
+	"ImagePullBackOff":           false,
+	"CreateContainerConfigError": false,
+}
+
+// jobTracker follows the Kubernetes Jobs and pods of one phase through
+// informers, so that the master reacts to status changes immediately.
+type jobTracker struct {
+	status   *jobStatus
+	jobDir   string
+	group    string
+	selector labels.Selector
+	jobs     batchlisters.JobLister
+	pods     corelisters.PodLister
+	// reported holds pod failure reasons that have already been logged.
+	reported map[string]bool
+	// waitingSince holds when pods were first seen waiting for a transient
+	// reason, keyed by pod name and reason.
+	waitingSince map[string]time.Time
+	waitingGrace time.Duration
+	now          func() time.Time
+}
+
+// waitForJobsToComplete blocks until all Kubernetes Jobs of a phase succeeded.
+// It returns an error describing the cause when a task fails permanently, and
+// ctx.Err() when ctx is cancelled or times out.
+func waitForJobsToComplete(ctx context.Context, clientset kubernetes.Interface, cfg *config.Config, status *jobStatus, jobId, group string) error {
+	selector := labels.SelectorFromSet(labels.Set{"job-group": jobId + "-" + group})
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(cfg.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+		}),
+	)
+	jobInformer := factory.Batch().V1().Jobs()
+	podInformer := factory.Core().V1().Pods()
+
+	changed := make(chan struct{}, 1)
+	notify := func(any) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj any) { notify(obj) },
+		DeleteFunc: notify,
+	}
+	if _, err := jobInformer.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+	if _, err := podInformer.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer factory.Shutdown()
+	defer close(stop)
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(ctx.Done(), jobInformer.Informer().HasSynced, podInformer.Informer().HasSynced) {
+		return ctx.Err()
+	}
+
+	tracker := &jobTracker{
+		status:       status,
+		jobDir:       filepath.Join(cfg.NfsPath, jobId),
+		group:        group,
+		selector:     selector,
+		jobs:         jobInformer.Lister(),
+		pods:         podInformer.Lister(),
+		reported:     make(map[string]bool),
+		waitingSince: make(map[string]time.Time),
+		waitingGrace: waitingGracePeriod,
+		now:          time.Now,
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		done, states, err := tracker.check()
+		if err != nil {
+			return err
+		}
+		if done {
+			slog.Info("All jobs completed.", "group", group)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-ticker.C:
+			slog.Info("Waiting for jobs to finish.", "group", group, "states", states)
+		}
+	}
+}
+
+// check updates the job status from the informer caches. It reports whether
+// all tasks succeeded and returns an error if one failed permanently.
+func (t *jobTracker) check() (bool, map[string]int, error) {
+	jobs, err := t.jobs.List(t.selector)
+	if err != nil {
+		return false, nil, err
+	}
+	pods, err := t.pods.List(t.selector)
+	if err != nil {
+		return false, nil, err
+	}
+
+	now := t.now()
+	waitingSince := make(map[string]time.Time)
+	defer func() { t.waitingSince = waitingSince }()
+	nodes := make(map[string]string)
+	podReasons := make(map[string]string)
+	created := make(map[string]time.Time)
+	for _, pod := range pods {
+		taskId := pod.Labels[taskIdLabel]
+		if reason := podFailureReason(pod); reason != "" {
+			podReasons[taskId] = reason
+			if !t.reported[pod.Name+reason] {
+				t.reported[pod.Name+reason] = true
+				slog.Warn("Task pod failed", "task", taskId, "pod", pod.Name, "reason", reason)
+			}
+			if waiting := waitingReason(pod); waiting != "" {
+				if fatal, ok := waitingReasons[waiting]; ok {
+					key := pod.Name + "/" + waiting
+					since, seen := t.waitingSince[key]
+					if !seen {
+						since = now
+					}
+					waitingSince[key] = since
+					if fatal {
+						return false, nil, fmt.Errorf("task %s cannot start: %s", taskId, reason)
+					}
+					if now.Sub(since) >= t.waitingGrace {
+						return false, nil, fmt.Errorf("task %s cannot start after %s: %s", taskId, t.waitingGrace, reason)
+					}
+				}
+			}
+		}
+		if pod.Spec.NodeName == "" || pod.CreationTimestamp.Time.Before(created[taskId]) {
+			continue
+		}
+		nodes[taskId] = pod.Spec.NodeName
+		created[taskId] = pod.CreationTimestamp.Time
+	}
+
+	allCompleted := true
+	states := map[string]int{"pending": 0, "active": 0, "succeeded": 0, "failed": 0}
+	for _, job := range jobs {
+		taskId := job.Labels[taskIdLabel]
+		state := jobState(job)
+		states[state]++
+
+		var taskCounters map[string]int64
+		if state == "succeeded" {
+			taskCounters, _ = counters.ReadFile(counters.TaskPath(t.jobDir, taskId))
+		} else {
+			allCompleted = false
+		}
+		t.status.updateTask(taskId, job, nodes[taskId], taskCounters)
+
+		if condition := failedCondition(job); condition != nil {
+			err := fmt.Errorf("task %s failed: %s: %s", taskId, condition.Reason, condition.Message)
+			if reason, ok := podReasons[taskId]; ok {
+				err = fmt.Errorf("%w (last pod: %s)", err, reason)
+			}
+			return false, states, err
+		}
+	}
+	for state, count := range states {
+		metrics.Tasks.WithLabelValues(metrics.Phase(t.group), state).Set(float64(count))
+	}
+	return allCompleted, states, nil
+}
+
+// jobState summarizes the status of a task's Kubernetes Job.
+func jobState(job *batchv1.Job) string {
+	switch {
+	case job.Status.Succeeded > 0:
+		return "succeeded"
+	case failedCondition(job) != nil:
+		return "failed"
+	case job.Status.Active > 0:
+		return "active"
+	default:
+		return "pending"
+	}
+}
+
+// failedCondition returns the condition marking a Kubernetes Job as
+// permanently failed, e.g. because its backoff limit was exceeded.
+func failedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == v1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// podFailureReason describes why a pod failed or cannot run, e.g. OOMKilled,
+// Evicted or ImagePullBackOff. It is empty for healthy pods.
+func podFailureReason(pod *v1.Pod) string {
+	if pod.Status.Reason != "" {
+		return fmt.Sprintf("%s: %s", pod.Status.Reason, pod.Status.Message)
+	}
+	for _, container := range pod.Status.ContainerStatuses {
+		if terminated := container.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			return fmt.Sprintf("%s (exit code %d)", terminated.Reason, terminated.ExitCode)
+		}
+		if waiting := container.State.Waiting; waiting != nil {
+			if _, ok := waitingReasons[waiting.Reason]; ok {
+				return fmt.Sprintf("%s: %s", waiting.Reason, waiting.Message)
+			}
+		}
+	}
+	return ""
+}
+
+func waitingReason(pod *v1.Pod) string {
+	for _, container := range pod.Status.ContainerStatuses {
+		if waiting := container.State.Waiting; waiting != nil {
+			return waiting.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,283 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+const trackerTestJobId = "job-test"
+
+// startTracker creates one mapper Job per file range and waits for their
+// completion in the background. It returns once the tracker watches Jobs and
+// pods, so that later updates are not missed.
+func startTracker(t *testing.T, ctx context.Context, fileRanges ...string) (*fake.Clientset, <-chan error) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	watching := make(chan string, 2)
+	client.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watching <- action.GetResource().Resource
+		return false, nil, nil
+	})
+
+	cfg := newSpecTestConfig()
+	cfg.NfsPath = t.TempDir()
+	status := newJobStatus(trackerTestJobId)
+	for i, fileRange := range fileRanges {
+		job := createMapperJobSpec(cfg, trackerTestJobId, fmt.Sprintf("mapper-%d", i), config.Input{Dir: cfg.InputDir}, fileRange, 0)
+		if _, err := client.BatchV1().Jobs("default").Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitForJobsToComplete(ctx, client, cfg, status, trackerTestJobId, "mapper")
+	}()
+	for range 2 {
+		select {
+		case <-watching:
+		case <-time.After(5 * time.Second):
+			t.Fatal("tracker did not start watching")
+		}
+	}
+	return client, done
+}
+
+func updateJobStatus(t *testing.T, client *fake.Clientset, taskId string, status batchv1.JobStatus) {
+	t.Helper()
+	name := kubernetesJobName(trackerTestJobId, taskId, 0)
+	job, err := client.BatchV1().Jobs("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status = status
+	if _, err := client.BatchV1().Jobs("default").UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createPod(t *testing.T, client *fake.Clientset, taskId string, status v1.PodStatus) {
+	t.Helper()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesJobName(trackerTestJobId, taskId, 0) + "-abcde",
+			Namespace: "default",
+			Labels:    taskLabels(trackerTestJobId, "mapper", taskId),
+		},
+		Spec:   v1.PodSpec{NodeName: "node-1"},
+		Status: status,
+	}
+	if _, err := client.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitResult(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker did not return")
+		return nil
+	}
+}
+
+func TestTrackerSucceeds(t *testing.T) {
+	client, done := startTracker(t, context.Background(), "book-0-4", "book-5-9")
+
+	updateJobStatus(t, client, "mapper-0", batchv1.JobStatus{Succeeded: 1})
+	select {
+	case err := <-done:
+		t.Fatalf("tracker returned before all tasks finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	updateJobStatus(t, client, "mapper-1", batchv1.JobStatus{Succeeded: 1})
+	if err := waitResult(t, done); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTrackerReportsFailureReason(t *testing.T) {
+	client, done := startTracker(t, context.Background(), "book-0-9")
+
+	createPod(t, client, "mapper-0", v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{{
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+		}},
+	})
+	updateJobStatus(t, client, "mapper-0", batchv1.JobStatus{
+		Failed: 1,
+		Conditions: []batchv1.JobCondition{{
+			Type:    batchv1.JobFailed,
+			Status:  v1.ConditionTrue,
+			Reason:  "BackoffLimitExceeded",
+			Message: "Job has reached the specified backoff limit",
+		}},
+	})
+
+	err := waitResult(t, done)
+	if err == nil || !strings.Contains(err.Error(), "BackoffLimitExceeded") || !strings.Contains(err.Error(), "OOMKilled") {
+		t.Errorf("expected failure with reasons, got %v", err)
+	}
+}
+
+func TestTrackerImagePullBackOff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	backOff := v1.PodStatus{
+		Phase: v1.PodPending,
+		ContainerStatuses: []v1.ContainerStatus{{
+			State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+		}},
+	}
+	running := v1.PodStatus{Phase: v1.PodRunning}
+
+	// A registry outage that clears within the grace period is survived.
+	pods, tracker, now := newTestTracker(t, time.Minute, backOff)
+	*now = start
+	if _, _, err := tracker.check(); err != nil {
+		t.Fatalf("failed on a new back-off: %v", err)
+	}
+	*now = start.Add(50 * time.Second)
+	if _, _, err := tracker.check(); err != nil {
+		t.Fatalf("failed within the grace period: %v", err)
+	}
+	setPodStatus(t, pods, running)
+	*now = start.Add(time.Hour)
+	if _, _, err := tracker.check(); err != nil {
+		t.Errorf("failed after the back-off cleared: %v", err)
+	}
+
+	// A back-off that persists fails the job after the grace period.
+	_, tracker, now = newTestTracker(t, time.Minute, backOff)
+	*now = start
+	if _, _, err := tracker.check(); err != nil {
+		t.Fatalf("failed on a new back-off: %v", err)
+	}
+	*now = start.Add(time.Minute)
+	_, _, err := tracker.check()
+	if err == nil || !strings.Contains(err.Error(), "ImagePullBackOff") {
+		t.Errorf("expected ImagePullBackOff failure, got %v", err)
+	}
+}
+
+func TestTrackerTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, done := startTracker(t, ctx, "book-0-9")
+
+	if err := waitResult(t, done); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestPodFailureReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		status v1.PodStatus
+		want   string
+	}{
+		{name: "running", status: v1.PodStatus{Phase: v1.PodRunning}, want: ""},
+		{name: "evicted", status: v1.PodStatus{Phase: v1.PodFailed, Reason: "Evicted", Message: "low on memory"}, want: "Evicted: low on memory"},
+		{
+			name: "oom killed",
+			status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+			}}},
+			want: "OOMKilled (exit code 137)",
+		},
+	}
+	for _, tt := range tests {
+		pod := &v1.Pod{Status: tt.status}
+		if got := podFailureReason(pod); got != tt.want {
+			t.Errorf("%s: podFailureReason = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newTestTracker returns a tracker of a single mapper pod with the given
+// status, the indexer holding the pod and the clock of the tracker.
+func newTestTracker(t *testing.T, grace time.Duration, status v1.PodStatus) (cache.Indexer, *jobTracker, *time.Time) {
+	t.Helper()
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	setPodStatus(t, pods, status)
+	now := new(time.Time)
+	tracker := &jobTracker{
+		status:       newJobStatus(trackerTestJobId),
+		jobDir:       t.TempDir(),
+		group:        "mapper",
+		selector:     labels.Everything(),
+		jobs:         batchlisters.NewJobLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		pods:         corelisters.NewPodLister(pods),
+		reported:     make(map[string]bool),
+		waitingSince: make(map[string]time.Time),
+		waitingGrace: grace,
+		now:          func() time.Time { return *now },
+	}
+	return pods, tracker, now
+}
+
+// setPodStatus adds or updates the mapper pod of newTestTracker.
+func setPodStatus(t *testing.T, pods cache.Indexer, status v1.PodStatus) {
+	t.Helper()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "mapper-0-abcde", Namespace: "default", Labels: map[string]string{taskIdLabel: "mapper-0"}},
+		Status:     status,
+	}
+	if err := pods.Update(pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackerWaitingReasons(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		reason string
+		// failAfter is how long the pod waits before the job fails.
+		failAfter time.Duration
+	}{
+		{"InvalidImageName", 0},
+		{"ErrImagePull", time.Minute},
+		{"ImagePullBackOff", time.Minute},
+		{"CreateContainerConfigError", time.Minute},
+		{"ContainerCreating", -1},
+	} {
+		t.Run(tc.reason, func(t *testing.T) {
+			_, tracker, now := newTestTracker(t, time.Minute, v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: tc.reason}},
+			}}})
+
+			for _, elapsed := range []time.Duration{0, 30 * time.Second, time.Minute, time.Hour} {
+				*now = start.Add(elapsed)
+				_, _, err := tracker.check()
+				if wantErr := tc.failAfter >= 0 && elapsed >= tc.failAfter; (err != nil) != wantErr {
+					t.Fatalf("after %s: err = %v, want error %v", elapsed, err, wantErr)
+				}
+				if err != nil {
+					if !strings.Contains(err.Error(), tc.reason) {
+						t.Errorf("error %q does not name the reason", err)
+					}
+					return
+				}
+			}
+		})
+	}
+}
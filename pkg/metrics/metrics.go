@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds all metrics exposed by master and workers, including Go
+// runtime and GC stats.
+var Registry = prometheus.NewRegistry()
+
+// Phase returns the phase of the tasks of a group or mode, mapper or reducer.
+// Metrics and log lines label phases as map or reduce. Other modes, e.g.
+// master, are their own phase.
+func Phase(group string) string {
+	switch group {
+	case "mapper":
+		return "map"
+	case "reducer":
+		return "reduce"
+	default:
+		return group
+	}
+}
+
+var (
+	// Tasks is the number of tasks of the running job by phase and state.
+	Tasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mapreduce",
+		Name:      "tasks",
+		Help:      "Number of tasks by phase and state.",
+	}, []string{"phase", "state"})
+
+	PhaseDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mapreduce",
+		Name:      "phase_duration_seconds",
+		Help:      "Wall time the last completed phase took.",
+	}, []string{"phase"})
+
+	RecordsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mapreduce",
+		Name:      "records_processed_total",
+		Help:      "Number of input records processed by phase.",
+	}, []string{"phase"})
+
+	BytesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mapreduce",
+		Name:      "bytes_written_total",
+		Help:      "Number of bytes written by phase.",
+	}, []string{"phase"})
+
+	ShuffleBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mapreduce",
+		Name:      "shuffle_bytes_total",
+		Help:      "Number of bytes reducers read from mapper partitions.",
+	})
+
+	MergeHeapSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mapreduce",
+		Name:      "merge_heap_size",
+		Help:      "Number of items in the reducer merge heap.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Tasks,
+		PhaseDuration,
+		RecordsProcessed,
+		BytesWritten,
+		ShuffleBytes,
+		MergeHeapSize,
+	)
+}
+
+// Handler serves the metrics in Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve registers /metrics on mux and serves mux on addr in the background.
+// It returns the address the server listens on, which differs from addr
+// when addr uses port 0.
+func Serve(addr string, mux *http.ServeMux) (string, error) {
+	mux.Handle("/metrics", Handler())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("HTTP server stopped", "err", err)
+		}
+	}()
+	return listener.Addr().String(), nil
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	addr, err := Serve("127.0.0.1:0", http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordsProcessed.WithLabelValues("map").Add(3)
+	Tasks.WithLabelValues(Phase("mapper"), "succeeded").Set(2)
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`mapreduce_records_processed_total{phase="map"} 3`,
+		`mapreduce_tasks{phase="map",state="succeeded"} 2`,
+		`go_gc_duration_seconds`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
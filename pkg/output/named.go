@@ -0,0 +1,154 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/MichalPitr/map_reduce/pkg/commit"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+// validName matches names of named outputs. Names starting with "_" or "."
+// are reserved for files that jobs ignore.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// NamedOutputs writes the records of a task to named outputs next to its main
+// output, e.g. a stream of rejected records. The named output "errors" of a
+// task whose output is <dir>/reducer-0 is committed to <dir>/errors/reducer-0
+// together with the task. Files are created on the first write, in the format
+// of the job.
+//
+// Write errors are sticky and returned by Close.
+type NamedOutputs struct {
+	final   string
+	attempt int
+	format  interfaces.OutputFormat
+	c       *counters.Counters
+	outputs map[string]*namedOutput
+	err     error
+}
+
+type namedOutput struct {
+	temp, final string
+	file        *os.File
+	counter     *CountingWriter
+	writer      interfaces.RecordWriter
+}
+
+// NewNamedOutputs returns the named outputs of an attempt of the task that
+// commits its output to final. An empty final rejects all writes, e.g. in
+// mappers whose output is partitioned for reducers.
+func NewNamedOutputs(final string, attempt int, format interfaces.OutputFormat, c *counters.Counters) *NamedOutputs {
+	if format == nil {
+		format = TextFormat{}
+	}
+	if final != "" {
+		final = filepath.Clean(final)
+	}
+	return &NamedOutputs{final: final, attempt: attempt, format: format, c: c, outputs: make(map[string]*namedOutput)}
+}
+
+// Write writes a record to the named output.
+func (n *NamedOutputs) Write(name, key, value string) {
+	if n.err != nil {
+		return
+	}
+	out, err := n.open(name)
+	if err != nil {
+		n.err = err
+		return
+	}
+	if err := out.writer.Write(key, value); err != nil {
+		n.err = fmt.Errorf("writing output %s: %w", name, err)
+		return
+	}
+	n.c.Inc(counters.NamedOutputRecords(name))
+}
+
+func (n *NamedOutputs) open(name string) (*namedOutput, error) {
+	if out, ok := n.outputs[name]; ok {
+		return out, nil
+	}
+	if n.final == "" {
+		return nil, errors.New("named outputs are only available to reducers and map-only mappers")
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid output name %q", name)
+	}
+	temp := commit.TempPath(n.final, n.attempt) + "." + name
+	if err := os.MkdirAll(filepath.Dir(temp), 0777); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(temp)
+	if err != nil {
+		return nil, err
+	}
+	counter := &CountingWriter{W: file}
+	out := &namedOutput{
+		temp:    temp,
+		final:   filepath.Join(filepath.Dir(n.final), name, filepath.Base(n.final)),
+		file:    file,
+		counter: counter,
+		writer:  n.format.NewRecordWriter(counter),
+	}
+	n.outputs[name] = out
+	return out, nil
+}
+
+// Close flushes and closes all named outputs. It returns the first error of
+// any write.
+func (n *NamedOutputs) Close() error {
+	for name, out := range n.outputs {
+		if err := out.writer.Flush(); err != nil && n.err == nil {
+			n.err = fmt.Errorf("writing output %s: %w", name, err)
+		}
+		if err := out.file.Close(); err != nil && n.err == nil {
+			n.err = err
+		}
+	}
+	return n.err
+}
+
+// BytesWritten returns the number of bytes written to all named outputs.
+func (n *NamedOutputs) BytesWritten() int64 {
+	var total int64
+	for _, out := range n.outputs {
+		total += out.counter.N
+	}
+	return total
+}
+
+// Paths maps the temporary paths of the named outputs to their final paths,
+// as expected by commit.CommitAll.
+func (n *NamedOutputs) Paths() map[string]string {
+	paths := make(map[string]string, len(n.outputs))
+	for _, out := range n.outputs {
+		paths[out.temp] = out.final
+	}
+	return paths
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the named outputs of a task.
+func NewContext(ctx context.Context, n *NamedOutputs) context.Context {
+	return context.WithValue(ctx, contextKey{}, n)
+}
+
+// Named returns a function that writes records to the named output of the
+// task in ctx, e.g. output.Named(input.Context(), "errors"). Without named
+// outputs in ctx, e.g. in unit tests of a Mapper, records are discarded.
+func Named(ctx context.Context, name string) func(key, value string) {
+	n, ok := ctx.Value(contextKey{}).(*NamedOutputs)
+	if !ok {
+		return func(key, value string) {}
+	}
+	return func(key, value string) {
+		n.Write(name, key, value)
+	}
+}
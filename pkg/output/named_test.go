@@ -0,0 +1,62 @@
+package output
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+)
+
+func TestNamedOutputs(t *testing.T) {
+	final := filepath.Join(t.TempDir(), "reducer-0")
+	c := counters.New()
+	n := NewNamedOutputs(final, 1, JSONLFormat{}, c)
+	ctx := NewContext(context.Background(), n)
+	Named(ctx, "errors")("a", "bad")
+	Named(ctx, "errors")("b", "worse")
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := n.Paths()
+	if len(paths) != 1 {
+		t.Fatalf("paths = %v", paths)
+	}
+	for temp, namedFinal := range paths {
+		if filepath.Base(filepath.Dir(temp)) != "_temporary" || namedFinal != filepath.Join(filepath.Dir(final), "errors", "reducer-0") {
+			t.Errorf("unexpected paths %s -> %s", temp, namedFinal)
+		}
+		data, err := os.ReadFile(temp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != n.BytesWritten() || strings.Count(string(data), "\n") != 2 {
+			t.Errorf("output = %q, %d bytes written", data, n.BytesWritten())
+		}
+	}
+	if got := c.Get(counters.NamedOutputRecords("errors")); got != 2 {
+		t.Errorf("records = %d, want 2", got)
+	}
+}
+
+func TestNamedOutputsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		final, name, errMsg string
+	}{
+		{filepath.Join(t.TempDir(), "reducer-0"), "_hidden", "invalid output name"},
+		{filepath.Join(t.TempDir(), "reducer-0"), "../escape", "invalid output name"},
+		{"", "errors", "only available to reducers and map-only mappers"},
+	} {
+		n := NewNamedOutputs(tc.final, 0, nil, counters.New())
+		n.Write(tc.name, "a", "1")
+		if err := n.Close(); err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%q: expected an error containing %q, got %v", tc.name, tc.errMsg, err)
+		}
+	}
+
+	// Without named outputs in the context, records are discarded.
+	Named(context.Background(), "errors")("a", "1")
+}
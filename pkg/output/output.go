@@ -0,0 +1,267 @@
+// Package output implements the built-in formats of a job's final output.
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+// Names of the built-in formats.
+const (
+	Text     = "text"
+	JSONL    = "jsonl"
+	CSV      = "csv"
+	TSV      = "tsv"
+	Sequence = "sequence"
+)
+
+var formats = map[string]interfaces.OutputFormat{
+	Text:     TextFormat{},
+	JSONL:    JSONLFormat{},
+	CSV:      CSVFormat{},
+	TSV:      TSVFormat{},
+	Sequence: SequenceFormat{},
+}
+
+// Lookup returns the built-in format with the given name.
+func Lookup(name string) (interfaces.OutputFormat, bool) {
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Names returns the names of the built-in formats.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TextFormat writes key,value lines without any quoting. It is the default
+// format and can be read by the mappers of another job.
+type TextFormat struct{}
+
+func (TextFormat) NewRecordWriter(w io.Writer) interfaces.RecordWriter {
+	return &textWriter{w: bufio.NewWriter(w)}
+}
+
+type textWriter struct {
+	w *bufio.Writer
+}
+
+func (tw *textWriter) Write(key, value string) error {
+	_, err := fmt.Fprintf(tw.w, "%s,%s\n", key, value)
+	return err
+}
+
+func (tw *textWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// JSONLFormat writes one {"key": ..., "value": ...} object per line.
+type JSONLFormat struct{}
+
+func (JSONLFormat) NewRecordWriter(w io.Writer) interfaces.RecordWriter {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+	return &jsonlWriter{w: bw, encoder: encoder}
+}
+
+type jsonlWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Record is a line written by JSONLFormat.
+type Record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (jw *jsonlWriter) Write(key, value string) error {
+	return jw.encoder.Encode(Record{Key: key, Value: value})
+}
+
+func (jw *jsonlWriter) Flush() error {
+	return jw.w.Flush()
+}
+
+// CSVFormat writes key,value rows as defined by RFC 4180. Fields containing
+// commas, quotes or newlines are quoted.
+type CSVFormat struct{}
+
+func (CSVFormat) NewRecordWriter(w io.Writer) interfaces.RecordWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) Write(key, value string) error {
+	return cw.w.Write([]string{key, value})
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// TSVFormat writes tab separated key and value lines. Tabs, newlines and
+// backslashes within fields are escaped as \t, \n, \r and \\.
+type TSVFormat struct{}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (TSVFormat) NewRecordWriter(w io.Writer) interfaces.RecordWriter {
+	return &tsvWriter{w: bufio.NewWriter(w)}
+}
+
+type tsvWriter struct {
+	w *bufio.Writer
+}
+
+func (tw *tsvWriter) Write(key, value string) error {
+	_, err := fmt.Fprintf(tw.w, "%s\t%s\n", tsvEscaper.Replace(key), tsvEscaper.Replace(value))
+	return err
+}
+
+func (tw *tsvWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// MaxRecordSize is the size limit of a key or value in sequence files and in
+// the partitions reducers read. Readers reject larger fields instead of
+// allocating them.
+const MaxRecordSize = 64 << 20
+
+// sequenceHeader starts every file written by SequenceFormat. The last byte
+// is the version of the format.
+var sequenceHeader = []byte("MRSEQ\x01")
+
+// SequenceFormat writes a binary file of length-prefixed records that can
+// hold arbitrary bytes. Each record is the uvarint length of the key, the
+// key, the uvarint length of the value and the value. Use NewSequenceReader
+// to read it.
+type SequenceFormat struct{}
+
+func (SequenceFormat) NewRecordWriter(w io.Writer) interfaces.RecordWriter {
+	return &sequenceWriter{w: bufio.NewWriter(w)}
+}
+
+type sequenceWriter struct {
+	w             *bufio.Writer
+	headerWritten bool
+}
+
+func (sw *sequenceWriter) Write(key, value string) error {
+	if err := sw.writeHeader(); err != nil {
+		return err
+	}
+	for _, field := range []string{key, value} {
+		if len(field) > MaxRecordSize {
+			return fmt.Errorf("field of %d bytes exceeds the limit of %d bytes", len(field), MaxRecordSize)
+		}
+		if _, err := sw.w.Write(binary.AppendUvarint(nil, uint64(len(field)))); err != nil {
+			return err
+		}
+		if _, err := sw.w.WriteString(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the header before the first record. Files without
+// records still get a header.
+func (sw *sequenceWriter) writeHeader() error {
+	if sw.headerWritten {
+		return nil
+	}
+	sw.headerWritten = true
+	_, err := sw.w.Write(sequenceHeader)
+	return err
+}
+
+func (sw *sequenceWriter) Flush() error {
+	if err := sw.writeHeader(); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// SequenceReader reads the records of a file written by SequenceFormat.
+type SequenceReader struct {
+	r *bufio.Reader
+}
+
+// NewSequenceReader checks the header of a sequence file and returns a reader
+// of its records.
+func NewSequenceReader(r io.Reader) (*SequenceReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(sequenceHeader))
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading sequence file header: %w", err)
+	}
+	if string(header) != string(sequenceHeader) {
+		return nil, errors.New("not a sequence file")
+	}
+	return &SequenceReader{r: br}, nil
+}
+
+// Read returns the next record. It returns io.EOF after the last record.
+func (sr *SequenceReader) Read() (key, value string, err error) {
+	key, err = sr.readField()
+	if err != nil {
+		return "", "", err
+	}
+	value, err = sr.readField()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return key, value, err
+}
+
+func (sr *SequenceReader) readField() (string, error) {
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return "", err
+	}
+	if n > MaxRecordSize {
+		return "", fmt.Errorf("field of %d bytes exceeds the limit of %d bytes", n, MaxRecordSize)
+	}
+	// The field grows as data arrives, so that a corrupt length below the
+	// limit fails on short data rather than allocating it upfront.
+	var field strings.Builder
+	if _, err := io.CopyN(&field, sr.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return field.String(), nil
+}
+
+// CountingWriter counts the bytes written to W.
+type CountingWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.W.Write(p)
+	cw.N += int64(n)
+	return n, err
+}
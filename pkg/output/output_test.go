@@ -0,0 +1,135 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var records = [][2]string{
+	{"plain", "1"},
+	{"comma,key", `quoted "value"`},
+	{"tab\tkey", "multi\nline\\"},
+	{"", ""},
+}
+
+func writeRecords(t *testing.T, name string) []byte {
+	t.Helper()
+	format, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("format %q not found", name)
+	}
+	var buf bytes.Buffer
+	w := format.NewRecordWriter(&buf)
+	for _, record := range records {
+		if err := w.Write(record[0], record[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTextFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		want   string
+	}{
+		{Text, "plain,1\ncomma,key,quoted \"value\"\ntab\tkey,multi\nline\\\n,\n"},
+		{TSV, "plain\t1\ncomma,key\tquoted \"value\"\ntab\\tkey\tmulti\\nline\\\\\n\t\n"},
+		{JSONL, `{"key":"plain","value":"1"}` + "\n" +
+			`{"key":"comma,key","value":"quoted \"value\""}` + "\n" +
+			`{"key":"tab\tkey","value":"multi\nline\\"}` + "\n" +
+			`{"key":"","value":""}` + "\n"},
+	} {
+		if got := string(writeRecords(t, tc.format)); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestCSVFormat(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(writeRecords(t, CSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(records) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(records))
+	}
+	for i, row := range rows {
+		if !slices.Equal(row, records[i][:]) {
+			t.Errorf("row %d = %q, want %q", i, row, records[i])
+		}
+	}
+}
+
+func TestSequenceFormat(t *testing.T) {
+	r, err := NewSequenceReader(bytes.NewReader(writeRecords(t, Sequence)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range records {
+		key, value, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != want[0] || value != want[1] {
+			t.Errorf("read %q, %q, want %q", key, value, want)
+		}
+	}
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestSequenceReaderErrors(t *testing.T) {
+	if _, err := NewSequenceReader(bytes.NewReader([]byte("plain,1\n"))); err == nil {
+		t.Error("expected an error for a text file")
+	}
+
+	data := writeRecords(t, Sequence)
+	r, err := NewSequenceReader(bytes.NewReader(data[:len(sequenceHeader)+3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF for a truncated record, got %v", err)
+	}
+
+	// A corrupt length must not allocate the field.
+	corrupt := append(slices.Clone(sequenceHeader), binary.AppendUvarint(nil, 1<<40)...)
+	r, err = NewSequenceReader(bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Read(); err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expected an error for an oversized field, got %v", err)
+	}
+	short := append(slices.Clone(sequenceHeader), binary.AppendUvarint(nil, 1<<20)...)
+	r, err = NewSequenceReader(bytes.NewReader(append(short, "abc"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF for a short field, got %v", err)
+	}
+
+	// Files without records only hold the header.
+	var empty bytes.Buffer
+	if err := (SequenceFormat{}).NewRecordWriter(&empty).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewSequenceReader(&empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
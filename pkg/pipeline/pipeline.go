@@ -0,0 +1,177 @@
+// Package pipeline describes multi-stage MapReduce pipelines, where the output
+// of one stage is the input of the stages that depend on it.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Stage is a MapReduce job within a pipeline. It reads the files in
+// InputDir, the output of the stage named Input, or several tagged Inputs.
+// Stages with no reducers are map-only and need no Reducer.
+type Stage struct {
+	Name     string `json:"name"`
+	Input    string `json:"input,omitempty"`
+	InputDir string `json:"inputDir,omitempty"`
+	// Inputs are read like the tagged inputs of a job, e.g. both sides of a
+	// join.
+	Inputs      []Input `json:"inputs,omitempty"`
+	Mapper      string  `json:"mapper"`
+	Reducer     string  `json:"reducer,omitempty"`
+	NumMappers  int     `json:"numMappers"`
+	NumReducers int     `json:"numReducers"`
+	// OutputFormat of the stage's output. Stages reading it default to the
+	// input format of the same name.
+	OutputFormat string `json:"outputFormat,omitempty"`
+	// InputFormat of the stage's input. Defaults to the OutputFormat of the
+	// stage named Input.
+	InputFormat string `json:"inputFormat,omitempty"`
+}
+
+// Input is one of several tagged inputs of a stage, like config.Input. It
+// reads either the files in Dir or the output of the stage named Stage, in
+// that stage's output format unless Format is set.
+type Input struct {
+	Tag    string `json:"tag"`
+	Stage  string `json:"stage,omitempty"`
+	Dir    string `json:"dir,omitempty"`
+	Mapper string `json:"mapper,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// dependencies returns the names of the stages that stage reads.
+func (stage *Stage) dependencies() []string {
+	var names []string
+	if stage.Input != "" {
+		names = append(names, stage.Input)
+	}
+	for _, input := range stage.Inputs {
+		if input.Stage != "" {
+			names = append(names, input.Stage)
+		}
+	}
+	return names
+}
+
+// Spec is a pipeline of stages.
+type Spec struct {
+	Name   string  `json:"name"`
+	Stages []Stage `json:"stages"`
+}
+
+// Load reads a pipeline spec in YAML or JSON format and validates it.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pipeline %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks that all stages are complete, that their inputs exist and
+// that they do not depend on each other in a cycle.
+func (s *Spec) Validate() error {
+	if len(s.Stages) == 0 {
+		return errors.New("pipeline has no stages")
+	}
+	stages := make(map[string]bool)
+	for _, stage := range s.Stages {
+		switch {
+		case stage.Name == "":
+			return errors.New("stage without a name")
+		case stages[stage.Name]:
+			return fmt.Errorf("duplicate stage %q", stage.Name)
+		case countSet(stage.Input != "", stage.InputDir != "", len(stage.Inputs) > 0) != 1:
+			return fmt.Errorf("stage %q must have exactly one of input, inputDir and inputs", stage.Name)
+		case stage.Mapper == "" || (stage.Reducer == "" && stage.NumReducers > 0):
+			return fmt.Errorf("stage %q must have a mapper and a reducer", stage.Name)
+		case stage.NumMappers < 1 || stage.NumReducers < 0:
+			return fmt.Errorf("stage %q must have at least one mapper and cannot have a negative number of reducers", stage.Name)
+		}
+		stages[stage.Name] = true
+	}
+	for _, stage := range s.Stages {
+		if err := validateInputs(&stage); err != nil {
+			return err
+		}
+		for _, name := range stage.dependencies() {
+			if !stages[name] {
+				return fmt.Errorf("stage %q reads unknown stage %q", stage.Name, name)
+			}
+		}
+	}
+	_, err := s.Order()
+	return err
+}
+
+// validateInputs checks that every tagged input of stage has a unique tag and
+// reads either a directory or a stage.
+func validateInputs(stage *Stage) error {
+	tags := make(map[string]bool, len(stage.Inputs))
+	for _, input := range stage.Inputs {
+		switch {
+		case input.Tag == "":
+			return fmt.Errorf("stage %q has an input without a tag", stage.Name)
+		case tags[input.Tag]:
+			return fmt.Errorf("stage %q has duplicate input tag %q", stage.Name, input.Tag)
+		case (input.Stage == "") == (input.Dir == ""):
+			return fmt.Errorf("input %q of stage %q must have exactly one of stage and dir", input.Tag, stage.Name)
+		}
+		tags[input.Tag] = true
+	}
+	return nil
+}
+
+// countSet returns the number of conditions that are true.
+func countSet(conditions ...bool) int {
+	n := 0
+	for _, set := range conditions {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// Order returns the stages in dependency order, so that every stage comes
+// after the stages it reads. Independent stages keep their order in the spec.
+func (s *Spec) Order() ([]Stage, error) {
+	ordered := make([]Stage, 0, len(s.Stages))
+	done := make(map[string]bool)
+	for len(ordered) < len(s.Stages) {
+		progress := false
+		for _, stage := range s.Stages {
+			if done[stage.Name] || !allDone(done, stage.dependencies()) {
+				continue
+			}
+			ordered = append(ordered, stage)
+			done[stage.Name] = true
+			progress = true
+		}
+		if !progress {
+			return nil, errors.New("stages depend on each other in a cycle")
+		}
+	}
+	return ordered, nil
+}
+
+// allDone reports whether all names are done.
+func allDone(done map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !done[name] {
+			return false
+		}
+	}
+	return true
+}
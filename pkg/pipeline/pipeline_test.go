@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.yaml")
+	spec := `
+name: word-frequencies
+stages:
+  - name: frequencies
+    input: count
+    mapper: frequency
+    reducer: sum
+    numMappers: 2
+    numReducers: 1
+  - name: count
+    inputDir: /mnt/nfs/input
+    mapper: wordcount
+    reducer: sum
+    numMappers: 4
+    numReducers: 2
+`
+	if err := os.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages, err := got.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 2 || stages[0].Name != "count" || stages[1].Name != "frequencies" {
+		t.Errorf("unexpected order %+v", stages)
+	}
+	if stages[0].InputDir != "/mnt/nfs/input" || stages[0].NumReducers != 2 {
+		t.Errorf("unexpected stage %+v", stages[0])
+	}
+}
+
+func TestOrderTaggedInputs(t *testing.T) {
+	spec := &Spec{Stages: []Stage{
+		{Name: "join", Inputs: []Input{{Tag: "counts", Stage: "count"}, {Tag: "top", Stage: "top"}}},
+		{Name: "top", Input: "count"},
+		{Name: "count", InputDir: "/input"},
+	}}
+	stages, err := spec.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	if want := []string{"count", "top", "join"}; !slices.Equal(names, want) {
+		t.Errorf("order = %v, want %v", names, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	stage := func(name, input string) Stage {
+		s := Stage{Name: name, Input: input, Mapper: "m", Reducer: "r", NumMappers: 1, NumReducers: 1}
+		if input == "" {
+			s.InputDir = "/input"
+		}
+		return s
+	}
+	tagged := func(name string, inputs ...Input) Stage {
+		return Stage{Name: name, Inputs: inputs, Mapper: "m", Reducer: "r", NumMappers: 1, NumReducers: 1}
+	}
+	tests := []struct {
+		name    string
+		stages  []Stage
+		wantErr string
+	}{
+		{name: "chain", stages: []Stage{stage("a", ""), stage("b", "a"), stage("c", "a")}},
+		{name: "empty", wantErr: "no stages"},
+		{name: "duplicate", stages: []Stage{stage("a", ""), stage("a", "")}, wantErr: "duplicate"},
+		{name: "unknown input", stages: []Stage{stage("a", "missing")}, wantErr: "unknown stage"},
+		{name: "cycle", stages: []Stage{stage("a", "b"), stage("b", "a")}, wantErr: "cycle"},
+		{name: "two inputs", stages: []Stage{{Name: "a", Input: "b", InputDir: "/input", Mapper: "m", Reducer: "r", NumMappers: 1, NumReducers: 1}}, wantErr: "exactly one"},
+		{name: "tagged inputs", stages: []Stage{stage("a", ""), tagged("b", Input{Tag: "x", Stage: "a"}, Input{Tag: "y", Dir: "/input"})}},
+		{name: "tagged and input dir", stages: []Stage{{Name: "a", InputDir: "/input", Inputs: []Input{{Tag: "x", Dir: "/x"}}, Mapper: "m", Reducer: "r", NumMappers: 1, NumReducers: 1}}, wantErr: "exactly one"},
+		{name: "input without tag", stages: []Stage{tagged("a", Input{Dir: "/input"})}, wantErr: "without a tag"},
+		{name: "duplicate tag", stages: []Stage{tagged("a", Input{Tag: "x", Dir: "/x"}, Input{Tag: "x", Dir: "/y"})}, wantErr: "duplicate input tag"},
+		{name: "input with stage and dir", stages: []Stage{stage("a", ""), tagged("b", Input{Tag: "x", Stage: "a", Dir: "/x"})}, wantErr: "exactly one of stage and dir"},
+		{name: "input without stage or dir", stages: []Stage{tagged("a", Input{Tag: "x"})}, wantErr: "exactly one of stage and dir"},
+		{name: "unknown tagged input", stages: []Stage{tagged("a", Input{Tag: "x", Stage: "missing"})}, wantErr: "unknown stage"},
+		{name: "tagged cycle", stages: []Stage{tagged("a", Input{Tag: "x", Stage: "b"}), stage("b", "a")}, wantErr: "cycle"},
+		{name: "map-only", stages: []Stage{{Name: "a", InputDir: "/input", Mapper: "m", NumMappers: 1}}},
+		{name: "no reducer", stages: []Stage{{Name: "a", InputDir: "/input", Mapper: "m", NumMappers: 1, NumReducers: 1}}, wantErr: "reducer"},
+		{name: "negative reducers", stages: []Stage{{Name: "a", InputDir: "/input", Mapper: "m", NumMappers: 1, NumReducers: -1}}, wantErr: "negative"},
+		{name: "no mapper", stages: []Stage{{Name: "a", InputDir: "/input", Reducer: "r", NumMappers: 1, NumReducers: 1}}, wantErr: "mapper"},
+	}
+	for _, tt := range tests {
+		err := (&Spec{Stages: tt.stages}).Validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package reducer
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+	"github.com/MichalPitr/map_reduce/pkg/shuffle"
+)
+
+// maxRecordLine is the longest key,value line of a partition: a key and a
+// value of up to output.MaxRecordSize bytes each and the comma.
+const maxRecordLine = 2*output.MaxRecordSize + 1
+
+// item is a key-value pair along with the index of its source file.
+type item struct {
+	key   string
+	value string
+	index int
+}
+
+// mergeQueue implements heap.Interface to manage a min-heap of items
+// ordered by compare on their keys.
+type mergeQueue struct {
+	items   []*item
+	compare func(a, b string) int
+}
+
+func (q *mergeQueue) Len() int { return len(q.items) }
+func (q *mergeQueue) Less(i, j int) bool {
+	return q.compare(q.items[i].key, q.items[j].key) < 0
+}
+func (q *mergeQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *mergeQueue) Push(x any) {
+	q.items = append(q.items, x.(*item))
+}
+
+func (q *mergeQueue) Pop() any {
+	old := q.items
+	n := len(old)
+	it := old[n-1]
+	q.items = old[0 : n-1]
+	return it
+}
+
+func (q *mergeQueue) peek() *item {
+	if len(q.items) > 0 {
+		return q.items[0]
+	}
+	return nil
+}
+
+// GroupIterator merges the key,value records of files sorted by an ordering
+// and iterates over their groups: runs of consecutive keys that the ordering
+// groups together.
+//
+//	for it.NextGroup() {
+//		for value := range it.Values() {
+//			...
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Reading a record that cannot be read or parsed ends the current group and
+// the iteration; Err returns the error.
+type GroupIterator struct {
+	files   []*os.File
+	readers []*bufio.Scanner
+	lines   []int
+	queue   mergeQueue
+	group   func(a, b string) int
+	// key is the key of the current value, or of the last value once the
+	// group is done.
+	key     string
+	inGroup bool
+	err     error
+}
+
+// NewGroupIterator opens files that are sorted by ordering.Compare and reads
+// their first records. The caller must Close the iterator.
+func NewGroupIterator(files []string, ordering shuffle.Ordering) (*GroupIterator, error) {
+	it := &GroupIterator{
+		files:   make([]*os.File, 0, len(files)),
+		readers: make([]*bufio.Scanner, 0, len(files)),
+		lines:   make([]int, len(files)),
+		queue:   mergeQueue{compare: ordering.Compare},
+		group:   ordering.Group,
+	}
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.files = append(it.files, f)
+		reader := bufio.NewScanner(f)
+		reader.Buffer(nil, maxRecordLine)
+		it.readers = append(it.readers, reader)
+	}
+	for i := range it.readers {
+		it.read(i)
+	}
+	if it.err != nil {
+		it.Close()
+		return nil, it.err
+	}
+	metrics.MergeHeapSize.Set(float64(it.queue.Len()))
+	return it, nil
+}
+
+// read pushes the next record of file i onto the queue.
+func (it *GroupIterator) read(i int) {
+	reader := it.readers[i]
+	if !reader.Scan() {
+		if err := reader.Err(); err != nil {
+			it.err = fmt.Errorf("reading %s: %w", it.files[i].Name(), err)
+		}
+		return
+	}
+	it.lines[i]++
+	metrics.ShuffleBytes.Add(float64(len(reader.Bytes()) + 1))
+	key, value, ok := strings.Cut(reader.Text(), ",")
+	if !ok {
+		it.err = fmt.Errorf("%s:%d: expected a key,value record", it.files[i].Name(), it.lines[i])
+		return
+	}
+	heap.Push(&it.queue, &item{key: key, value: value, index: i})
+}
+
+// NextGroup advances to the next group and reports whether there is one.
+// Values of the current group that were not read are skipped.
+func (it *GroupIterator) NextGroup() bool {
+	for it.inGroup {
+		it.NextValue()
+	}
+	if it.err != nil || it.queue.Len() == 0 {
+		return false
+	}
+	it.key = it.queue.peek().key
+	it.inGroup = true
+	return true
+}
+
+// Key returns the key of the current value. Keys within a group differ when
+// the ordering groups different keys.
+func (it *GroupIterator) Key() string {
+	return it.key
+}
+
+// Value returns the current value of the group.
+func (it *GroupIterator) Value() string {
+	if !it.inGroup {
+		return ""
+	}
+	return it.queue.peek().value
+}
+
+// NextValue advances to the next value of the group. It does nothing once
+// the group is done.
+func (it *GroupIterator) NextValue() {
+	if !it.inGroup {
+		return
+	}
+	current := heap.Pop(&it.queue).(*item)
+	it.read(current.index)
+	metrics.MergeHeapSize.Set(float64(it.queue.Len()))
+
+	next := it.queue.peek()
+	if it.err != nil || next == nil || it.group(next.key, current.key) != 0 {
+		it.inGroup = false
+		return
+	}
+	it.key = next.key
+}
+
+// Done reports whether all values of the current group were read.
+func (it *GroupIterator) Done() bool {
+	return !it.inGroup
+}
+
+// Values iterates over the remaining values of the current group.
+func (it *GroupIterator) Values() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for ; !it.Done(); it.NextValue() {
+			if !yield(it.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the first error reading or parsing the files.
+func (it *GroupIterator) Err() error {
+	return it.err
+}
+
+// Close closes the files.
+func (it *GroupIterator) Close() error {
+	var errs []error
+	for _, f := range it.files {
+		errs = append(errs, f.Close())
+	}
+	it.files = nil
+	return errors.Join(errs...)
+}
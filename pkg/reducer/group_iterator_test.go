@@ -0,0 +1,179 @@
+package reducer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/shuffle"
+)
+
+// writeFiles writes every element of contents to its own file and returns
+// their paths.
+func writeFiles(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(contents))
+	for i, content := range contents {
+		paths[i] = filepath.Join(dir, "partition-"+string(rune('a'+i)))
+		if err := os.WriteFile(paths[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+// readGroups returns every group as key:values, with the values in the
+// order they were read.
+func readGroups(it *GroupIterator) []string {
+	var groups []string
+	for it.NextGroup() {
+		key := it.Key()
+		var values []string
+		for value := range it.Values() {
+			values = append(values, value)
+		}
+		groups = append(groups, key+":"+strings.Join(values, " "))
+	}
+	return groups
+}
+
+// byUser groups user|timestamp keys by user.
+var byUser = shuffle.Ordering{
+	Compare: strings.Compare,
+	Group: func(a, b string) int {
+		userA, _, _ := strings.Cut(a, "|")
+		userB, _, _ := strings.Cut(b, "|")
+		return strings.Compare(userA, userB)
+	},
+}
+
+// longValue is longer than the default token size of bufio.Scanner.
+var longValue = strings.Repeat("x", 1<<20)
+
+func TestGroupIterator(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    []string
+		ordering shuffle.Ordering
+		want     []string
+	}{
+		{"no files", nil, shuffle.Default, nil},
+		{"empty files", []string{"", ""}, shuffle.Default, nil},
+		{"single file", []string{"a,1\na,2\nb,3\n"}, shuffle.Default, []string{"a:1 2", "b:3"}},
+		{"merges files", []string{"a,1\nc,3\n", "", "b,2\nd,4\n"}, shuffle.Default, []string{"a:1", "b:2", "c:3", "d:4"}},
+		{"values with commas", []string{"a,1,2\nb,\n"}, shuffle.Default, []string{"a:1,2", "b:"}},
+		{"long record", []string{"a,1\nb," + longValue + "\n"}, shuffle.Default, []string{"a:1", "b:" + longValue}},
+		{"grouping", []string{"alice|1,login\nalice|3,logout\nbob|2,login\n"}, byUser, []string{"alice|1:login logout", "bob|2:login"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			it, err := NewGroupIterator(writeFiles(t, tc.files...), tc.ordering)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer it.Close()
+			if got := readGroups(it); !slices.Equal(got, tc.want) {
+				t.Errorf("groups = %q, want %q", got, tc.want)
+			}
+			if err := it.Err(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGroupIteratorMergesEqualKeys(t *testing.T) {
+	it, err := NewGroupIterator(writeFiles(t, "a,1\nb,1\n", "a,2\nb,2\n", "b,3\n"), shuffle.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	// The order of equal keys from different files is unspecified.
+	var groups []string
+	for it.NextGroup() {
+		key := it.Key()
+		values := slices.Sorted(it.Values())
+		groups = append(groups, key+":"+strings.Join(values, " "))
+	}
+	want := []string{"a:1 2", "b:1 2 3"}
+	if !slices.Equal(groups, want) || it.Err() != nil {
+		t.Errorf("groups = %q, err = %v, want %q", groups, it.Err(), want)
+	}
+}
+
+func TestGroupIteratorSkipsUnreadValues(t *testing.T) {
+	it, err := NewGroupIterator(writeFiles(t, "a,1\na,2\na,3\nb,4\n"), shuffle.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var got []string
+	for it.NextGroup() {
+		got = append(got, it.Key()+":"+it.Value())
+	}
+	if want := []string{"a:1", "b:4"}; !slices.Equal(got, want) {
+		t.Errorf("first values = %q, want %q", got, want)
+	}
+	it.NextValue()
+	if !it.Done() || it.Value() != "" {
+		t.Error("values are available after the last group")
+	}
+}
+
+func TestGroupIteratorErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files []string
+		// want is the groups read before the error.
+		want    []string
+		wantErr string
+	}{
+		{"malformed first record", []string{"a,1\n", "no comma\n"}, nil, "partition-b:1: expected a key,value record"},
+		{"malformed record", []string{"a,1\na,2\nbad\nc,3\n"}, []string{"a:1 2"}, "partition-a:3: expected a key,value record"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := writeFiles(t, tc.files...)
+			it, err := NewGroupIterator(files, shuffle.Default)
+			if err != nil {
+				if tc.want != nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("NewGroupIterator error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			defer it.Close()
+			if got := readGroups(it); !slices.Equal(got, tc.want) {
+				t.Errorf("groups = %q, want %q", got, tc.want)
+			}
+			if err := it.Err(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Err() = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGroupIteratorMissingFile(t *testing.T) {
+	files := writeFiles(t, "a,1\n")
+	files = append(files, filepath.Join(t.TempDir(), "missing"))
+	if _, err := NewGroupIterator(files, shuffle.Default); !os.IsNotExist(err) {
+		t.Errorf("error = %v, want a missing file", err)
+	}
+}
+
+func TestGroupIteratorClose(t *testing.T) {
+	it, err := NewGroupIterator(writeFiles(t, "a,1\n", "b,2\n"), shuffle.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := slices.Clone(it.files)
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("%s is still open: %v", f.Name(), err)
+		}
+	}
+}
@@ -1,26 +1,78 @@
 package reducer
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"log"
+	"iter"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/MichalPitr/map_reduce/pkg/cache"
+	"github.com/MichalPitr/map_reduce/pkg/commit"
 	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/counters"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/logging"
+	"github.com/MichalPitr/map_reduce/pkg/metrics"
+	"github.com/MichalPitr/map_reduce/pkg/output"
+	"github.com/MichalPitr/map_reduce/pkg/shuffle"
 )
 
+// reducerInput exposes the GroupIterator to Reduce functions along with the task context.
+type reducerInput struct {
+	*GroupIterator
+	ctx      context.Context
+	counters *counters.Counters
+}
+
+func (ri *reducerInput) Context() context.Context {
+	return ri.ctx
+}
+
+// NextValue advances to the next value of the group. It does nothing once
+// the group is done, so that Reduce cannot read into the next group.
+func (ri *reducerInput) NextValue() {
+	if ri.Done() {
+		return
+	}
+	ri.counters.Inc(counters.ReduceInputRecords)
+	metrics.RecordsProcessed.WithLabelValues("reduce").Inc()
+	ri.GroupIterator.NextValue()
+}
+
+// Values iterates over the remaining values of the group. Stopping early is
+// fine; the values left are skipped before the next group.
+func (ri *reducerInput) Values() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for ; !ri.Done(); ri.NextValue() {
+			if !yield(ri.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// skipGroup consumes the values of the group that Reduce left unread.
+func (ri *reducerInput) skipGroup() {
+	for !ri.Done() {
+		ri.counters.Inc(counters.ReduceSkippedValues)
+		ri.NextValue()
+	}
+}
+
 func Run(cfg *config.Config) {
-	log.Printf("Running reducer...")
-	log.Printf("Reducer input dir: %s", cfg.InputDir)
+	slog.Info("Running reducer", "inputDir", cfg.InputDir, "reducerId", cfg.ReducerId)
 	partitionFiles := make([]string, 0, cfg.NumReducers)
 	inputFiles, err := os.ReadDir(cfg.InputDir)
 	if err != nil {
-		log.Fatalf("Failed to read dir %s: %v", cfg.InputDir, err)
+		logging.Fatal("Failed to read dir", "dir", cfg.InputDir, "err", err)
 	}
 
 	for _, file := range inputFiles {
-		if !file.IsDir() {
+		// Only mapper output directories hold partitions.
+		if !file.IsDir() || !strings.HasPrefix(file.Name(), "mapper-") {
 			continue
 		}
 		partitionName := fmt.Sprintf("partition-%d", cfg.ReducerId)
@@ -29,41 +81,133 @@ func Run(cfg *config.Config) {
 		partitionFiles = append(partitionFiles, partition)
 	}
 
-	results := make(map[string][]string)
-	reducer := cfg.Reducer
+	// Results are written to a temporary file that is committed once complete,
+	// so that retried or resumed attempts never see partial output. Named
+	// outputs are committed along with it.
+	outputFilePath := filepath.Join(cfg.OutputDir, fmt.Sprintf("reducer-%d", cfg.ReducerId))
+	tempFilePath := commit.TempPath(outputFilePath, cfg.Attempt)
 
-	// Start reading partitions and on-the-fly merge.
-	sm := NewStreamMerger(partitionFiles)
-	for sm.pq.Len() > 0 {
-		key := sm.Key()
-		emit := func(value string) {
-			results[key] = append(results[key], value)
-		}
+	c := counters.New()
+	named := output.NewNamedOutputs(outputFilePath, cfg.Attempt, cfg.OutputFormat, c)
+	ctx := output.NewContext(counters.NewContext(context.Background(), c), named)
+	taskCache, err := cache.Load(cfg)
+	if err != nil {
+		logging.Fatal("Failed to load the distributed cache", "err", err)
+	}
+	ctx = cache.NewContext(ctx, taskCache)
+	reducer := keyedReducer(cfg)
 
-		reducer.Reduce(sm, emit)
-		// reset so that we can process the next key
-		sm.done = false
+	if s, ok := reducer.(interfaces.Setuper); ok {
+		if err := s.Setup(ctx); err != nil {
+			logging.Fatal("Reducer setup failed", "err", err)
+		}
 	}
 
-	// Prepare output dir
-	if err := os.MkdirAll(cfg.OutputDir, 0777); err != nil {
-		log.Fatalf("Creating directory %s failed: %v", cfg.OutputDir, err)
+	if err := os.MkdirAll(filepath.Dir(tempFilePath), 0777); err != nil {
+		logging.Fatal("Creating directory failed", "dir", filepath.Dir(tempFilePath), "err", err)
 	}
 
-	//Save results to disk, probably to job-id/out/reducer-{id} file.
+	file, err := os.OpenFile(tempFilePath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logging.Fatal("Failed to open file", "path", tempFilePath, "err", err)
+	}
+	format := cfg.OutputFormat
+	if format == nil {
+		format = output.TextFormat{}
+	}
+	counter := &output.CountingWriter{W: file}
+	writer := format.NewRecordWriter(counter)
+	// Records are streamed to the output as they are emitted, so groups do
+	// not have to fit in memory and every emitted record is kept.
+	emit := func(key, value string) {
+		if err := writer.Write(key, value); err != nil {
+			logging.Fatal("Failed to write to a file", "err", err)
+		}
+		c.Inc(counters.ReduceOutputRecords)
+	}
 
-	outputFilePath := filepath.Join(cfg.OutputDir, fmt.Sprintf("reducer-%d", cfg.ReducerId))
-	file, err := os.OpenFile(outputFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// Start reading partitions and on-the-fly merge.
+	groups, err := NewGroupIterator(partitionFiles, shuffle.For(cfg))
 	if err != nil {
-		log.Fatalf("Failed to open file: %v", err)
-	}
-	defer file.Close()
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-	for key, values := range results {
-		_, err := writer.WriteString(fmt.Sprintf("%s,%s\n", key, values[0]))
-		if err != nil {
-			log.Fatalf("Failed to write to a file: %v", err)
+		logging.Fatal("Failed to open partitions", "err", err)
+	}
+	input := &reducerInput{GroupIterator: groups, ctx: ctx, counters: c}
+	for groups.NextGroup() {
+		c.Inc(counters.ReduceInputGroups)
+		reducer.Reduce(input, emit)
+		// Values Reduce did not consume are counted before NextGroup
+		// skips them.
+		input.skipGroup()
+	}
+	if err := groups.Err(); err != nil {
+		logging.Fatal("Failed to read partitions", "err", err)
+	}
+	if err := groups.Close(); err != nil {
+		logging.Fatal("Failed to close partitions", "err", err)
+	}
+
+	if cl, ok := reducer.(interfaces.Cleaner); ok {
+		if err := cl.Cleanup(ctx, emit); err != nil {
+			logging.Fatal("Reducer cleanup failed", "err", err)
 		}
 	}
+
+	if err := writer.Flush(); err != nil {
+		logging.Fatal("Failed to write to a file", "err", err)
+	}
+	if err := file.Close(); err != nil {
+		logging.Fatal("Failed to close file", "path", tempFilePath, "err", err)
+	}
+	if err := named.Close(); err != nil {
+		logging.Fatal("Failed to write named outputs", "err", err)
+	}
+	written := counter.N + named.BytesWritten()
+	c.Add(counters.ReduceBytesWritten, written)
+	metrics.BytesWritten.WithLabelValues("reduce").Add(float64(written))
+
+	committed, err := commit.CommitTask(tempFilePath, outputFilePath, named.Paths(), cfg.JobId != "")
+	if err != nil {
+		logging.Fatal("Failed to commit output", "err", err)
+	}
+	if !committed {
+		slog.Warn("Output was already committed by another attempt, discarding")
+		return
+	}
+	if err := counters.Save(c, cfg.JobId, cfg.JobDir(), cfg.TaskId); err != nil {
+		logging.Fatal("Failed to save counters", "err", err)
+	}
+}
+
+// keyedReducer returns the reducer of the job. A Reducer is adapted to emit
+// its values under the input key.
+func keyedReducer(cfg *config.Config) interfaces.KeyedReducer {
+	if cfg.KeyedReducer != nil {
+		return cfg.KeyedReducer
+	}
+	return valueReducer{cfg.Reducer}
+}
+
+// valueReducer adapts a Reducer to a KeyedReducer. Setup and Cleanup of the
+// wrapped Reducer are still called.
+type valueReducer struct {
+	interfaces.Reducer
+}
+
+func (vr valueReducer) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {
+	key := input.Key()
+	vr.Reducer.Reduce(input, func(value string) { emit(key, value) })
+}
+
+func (vr valueReducer) Setup(ctx context.Context) error {
+	if s, ok := vr.Reducer.(interfaces.Setuper); ok {
+		return s.Setup(ctx)
+	}
+	return nil
+}
+
+func (vr valueReducer) Cleanup(ctx context.Context, emit func(key, value string)) error {
+	if cl, ok := vr.Reducer.(interfaces.Cleaner); ok {
+		return cl.Cleanup(ctx, emit)
+	}
+	return nil
 }
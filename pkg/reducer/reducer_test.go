@@ -1,12 +1,18 @@
 package reducer
 
 import (
+	"context"
 	"log"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/MichalPitr/map_reduce/pkg/config"
 	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+	"github.com/MichalPitr/map_reduce/pkg/output"
 )
 
 func BenchmarkReducer(b *testing.B) {
@@ -41,3 +47,258 @@ func NewTestConfig() *config.Config {
 	cfg := config.Config{}
 	return &cfg
 }
+
+// writePartition writes the partition-0 file of a mapper in inputDir.
+func writePartition(t *testing.T, inputDir, mapperId, partition string) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(inputDir, mapperId), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, mapperId, "partition-0"), []byte(partition), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	inputDir := t.TempDir()
+	writePartition(t, inputDir, "mapper-0", "a,1\na,2\n")
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = t.TempDir()
+	cfg.Reducer = &Adder{}
+	cfg.OutputFormat = output.JSONLFormat{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "reducer-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"key":"a","value":"3"}` + "\n"
+	if string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// postings builds an inverted index from word,document pairs. It emits every
+// document under the word and the number of documents under a new key.
+type postings struct {
+	words int
+}
+
+func (p *postings) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {
+	word := input.Key()
+	n := 0
+	for ; !input.Done(); input.NextValue() {
+		emit(word, input.Value())
+		n++
+	}
+	emit("count:"+word, strconv.Itoa(n))
+	p.words++
+}
+
+func (p *postings) Cleanup(ctx context.Context, emit func(key, value string)) error {
+	emit("words", strconv.Itoa(p.words))
+	return nil
+}
+
+func TestKeyedReducer(t *testing.T) {
+	inputDir := t.TempDir()
+	writePartition(t, inputDir, "mapper-0", "a,doc-1\nb,doc-1\n")
+	writePartition(t, inputDir, "mapper-1", "a,doc-2\n")
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = t.TempDir()
+	cfg.KeyedReducer = &postings{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "reducer-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	// The order of values within a key depends on the merge.
+	slices.Sort(lines[:2])
+	want := []string{"a,doc-1", "a,doc-2", "count:a,2", "b,doc-1", "count:b,1", "words,2"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
+
+// validatingAdder sums numeric values and writes the others to the
+// "rejected" output.
+type validatingAdder struct{}
+
+func (va *validatingAdder) Reduce(input interfaces.ReducerInput, emit func(value string)) {
+	rejected := output.Named(input.Context(), "rejected")
+	sum := 0
+	for ; !input.Done(); input.NextValue() {
+		n, err := strconv.Atoi(input.Value())
+		if err != nil {
+			rejected(input.Key(), input.Value())
+			continue
+		}
+		sum += n
+	}
+	emit(strconv.Itoa(sum))
+}
+
+func TestNamedOutputs(t *testing.T) {
+	inputDir := t.TempDir()
+	writePartition(t, inputDir, "mapper-0", "a,1\na,x\nb,2\n")
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = t.TempDir()
+	cfg.Reducer = &validatingAdder{}
+	Run(cfg)
+
+	for path, want := range map[string]string{
+		"reducer-0":          "a,1\nb,2\n",
+		"rejected/reducer-0": "a,x\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(cfg.OutputDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	// Nothing but the committed outputs is left in the temporary directory.
+	entries, err := os.ReadDir(filepath.Join(cfg.OutputDir, "_temporary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+// firstValue emits the first value of every group with Value.
+type firstValue struct{}
+
+func (fv *firstValue) Reduce(input interfaces.ReducerInput, emit func(value string)) {
+	emit(input.Value())
+}
+
+// firstOfValues emits the first value of every group with Values.
+type firstOfValues struct{}
+
+func (fv *firstOfValues) Reduce(input interfaces.ReducerInput, emit func(value string)) {
+	for value := range input.Values() {
+		emit(value)
+		break
+	}
+}
+
+func TestUnconsumedValuesAreSkipped(t *testing.T) {
+	for name, reducer := range map[string]interfaces.Reducer{
+		"value":  &firstValue{},
+		"values": &firstOfValues{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			writePartition(t, inputDir, "mapper-0", "a,1\na,2\na,3\nb,4\nb,5\n")
+
+			cfg := NewTestConfig()
+			cfg.InputDir = inputDir
+			cfg.OutputDir = t.TempDir()
+			cfg.Reducer = reducer
+			Run(cfg)
+
+			got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "reducer-0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "a,1\nb,4\n" {
+				t.Errorf("output = %q", got)
+			}
+		})
+	}
+}
+
+func TestReducerKeepsAllValues(t *testing.T) {
+	inputDir := t.TempDir()
+	writePartition(t, inputDir, "mapper-0", "a,1\na,2\n")
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = t.TempDir()
+	cfg.Reducer = &echo{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "reducer-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a,1\na,2\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+// echo emits every value of a key.
+type echo struct{}
+
+func (e *echo) Reduce(input interfaces.ReducerInput, emit func(value string)) {
+	for ; !input.Done(); input.NextValue() {
+		emit(input.Value())
+	}
+}
+
+// eventsByUser receives user|timestamp keys grouped by user and sorted by
+// timestamp, and emits the events of each user in order.
+type eventsByUser struct{}
+
+func splitEventKey(key string) (string, int) {
+	user, ts, _ := strings.Cut(key, "|")
+	n, _ := strconv.Atoi(ts)
+	return user, n
+}
+
+func (eventsByUser) CompareKeys(a, b string) int {
+	userA, tsA := splitEventKey(a)
+	userB, tsB := splitEventKey(b)
+	if c := strings.Compare(userA, userB); c != 0 {
+		return c
+	}
+	return tsA - tsB
+}
+
+func (eventsByUser) CompareGroups(a, b string) int {
+	userA, _ := splitEventKey(a)
+	userB, _ := splitEventKey(b)
+	return strings.Compare(userA, userB)
+}
+
+func (eventsByUser) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {
+	user, _ := splitEventKey(input.Key())
+	events := make([]string, 0)
+	for ; !input.Done(); input.NextValue() {
+		events = append(events, input.Value())
+	}
+	emit(user, strings.Join(events, " "))
+}
+
+func TestSecondarySort(t *testing.T) {
+	// Partitions are sorted by timestamp, which is not the byte order.
+	inputDir := t.TempDir()
+	writePartition(t, inputDir, "mapper-0", "alice|2,login\nalice|10,logout\nbob|5,login\n")
+	writePartition(t, inputDir, "mapper-1", "alice|9,click\nbob|30,logout\n")
+
+	cfg := NewTestConfig()
+	cfg.InputDir = inputDir
+	cfg.OutputDir = t.TempDir()
+	cfg.KeyedReducer = eventsByUser{}
+	Run(cfg)
+
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "reducer-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "alice,login click logout\nbob,login logout\n"
+	if string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+// Package shuffle defines how intermediate pairs are partitioned between
+// reducers, sorted and grouped into the input of Reduce calls.
+package shuffle
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+// Ordering is the shuffle of a job. Mappers use Partition and Compare to
+// write sorted partitions; reducers use Compare to merge them and Group to
+// find the keys passed to the same Reduce call.
+type Ordering struct {
+	// Partition returns the reducer of a key, in [0, numPartitions).
+	Partition func(key string, numPartitions int) int
+	// Compare returns a negative number when a sorts before b, 0 when they
+	// are equal and a positive number otherwise.
+	Compare func(a, b string) int
+	// Group returns 0 when a and b belong to the same group.
+	Group func(a, b string) int
+}
+
+// Default partitions keys by hash, sorts them by bytes and groups equal keys.
+var Default = Ordering{
+	Partition: HashPartition,
+	Compare:   strings.Compare,
+	Group:     strings.Compare,
+}
+
+// For returns the ordering requested by the reducer of cfg through the
+// optional Partitioner, SortComparator and GroupingComparator interfaces.
+// Mappers and reducers of a job must use the same reducer.
+func For(cfg *config.Config) Ordering {
+	var reducer any = cfg.Reducer
+	if cfg.KeyedReducer != nil {
+		reducer = cfg.KeyedReducer
+	}
+	ordering := Default
+	if p, ok := reducer.(interfaces.Partitioner); ok {
+		ordering.Partition = p.Partition
+	}
+	if s, ok := reducer.(interfaces.SortComparator); ok {
+		ordering.Compare = s.CompareKeys
+	}
+	if g, ok := reducer.(interfaces.GroupingComparator); ok {
+		ordering.Group = g.CompareGroups
+	}
+	return ordering
+}
+
+// HashPartition assigns keys to partitions the way mappers always have: by
+// the byte count the FNV-1a hash reports for the key, which is its length.
+func HashPartition(key string, numPartitions int) int {
+	h := fnv.New32a()
+	n, _ := h.Write([]byte(key))
+	return n % numPartitions
+}
@@ -0,0 +1,62 @@
+package shuffle
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MichalPitr/map_reduce/pkg/config"
+	"github.com/MichalPitr/map_reduce/pkg/interfaces"
+)
+
+type plainReducer struct{}
+
+func (plainReducer) Reduce(input interfaces.ReducerInput, emit func(string)) {}
+
+// byUser groups keys of the form user|timestamp by user.
+type byUser struct{}
+
+func (byUser) Reduce(input interfaces.ReducerInput, emit func(key, value string)) {}
+
+func (byUser) Partition(key string, numPartitions int) int {
+	user, _, _ := strings.Cut(key, "|")
+	return HashPartition(user, numPartitions)
+}
+
+func (byUser) CompareKeys(a, b string) int { return -strings.Compare(a, b) }
+
+func (byUser) CompareGroups(a, b string) int {
+	userA, _, _ := strings.Cut(a, "|")
+	userB, _, _ := strings.Cut(b, "|")
+	return strings.Compare(userA, userB)
+}
+
+func TestFor(t *testing.T) {
+	ordering := For(&config.Config{Reducer: plainReducer{}})
+	if ordering.Compare("a", "b") >= 0 || ordering.Group("a|1", "a|2") == 0 {
+		t.Error("expected the default ordering for a reducer without comparators")
+	}
+
+	ordering = For(&config.Config{Reducer: plainReducer{}, KeyedReducer: byUser{}})
+	if ordering.Compare("a", "b") <= 0 {
+		t.Error("sort comparator of the keyed reducer not used")
+	}
+	if ordering.Group("a|1", "a|2") != 0 || ordering.Group("a|1", "b|1") == 0 {
+		t.Error("grouping comparator of the keyed reducer not used")
+	}
+	if ordering.Partition("a|1", 8) != ordering.Partition("a|2", 8) {
+		t.Error("partitioner of the keyed reducer not used")
+	}
+}
+
+func TestHashPartition(t *testing.T) {
+	for _, key := range []string{"", "a", "ab", "abc", "abcd", "abcde"} {
+		p := HashPartition(key, 4)
+		if p < 0 || p >= 4 {
+			t.Fatalf("partition of %q = %d", key, p)
+		}
+		// Jobs resumed across upgrades rely on a stable assignment.
+		if want := len(key) % 4; p != want {
+			t.Errorf("partition of %q = %d, want %d", key, p, want)
+		}
+	}
+}